@@ -0,0 +1,253 @@
+package lagoon
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ERR_BREAKER_THRESHOLD = fmt.Errorf("Breaker Threshold Not Between 0 And 1")
+	ERR_BREAKER_WINDOW    = fmt.Errorf("Breaker Window < 1")
+	ERR_BREAKER_COOLDOWN  = fmt.Errorf("Breaker Cooldown < 1")
+)
+
+type BreakerState int
+
+const (
+	// dials go through
+	BREAKER_CLOSED BreakerState = iota
+	// dials fail fast with ErrCircuitOpen until Cooldown has passed
+	BREAKER_OPEN
+	// a single probe dial decides whether we close or open again
+	BREAKER_HALF_OPEN
+)
+
+func (self BreakerState) String() string {
+	switch self {
+	case BREAKER_CLOSED:
+		return "closed"
+	case BREAKER_OPEN:
+		return "open"
+	case BREAKER_HALF_OPEN:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// the breaker opens once Threshold of the last Window dials have failed
+type Breaker struct {
+	// failure rate, 0.5 opens once half of our dials have failed
+	Threshold float64
+	// how many of our most recent dials are considered, we never open before dialing Window times
+	Window   int
+	Cooldown time.Duration
+}
+
+func (self *Breaker) Clone() *Breaker {
+	if self == nil {
+		return nil
+	}
+	return &Breaker{
+		Threshold: self.Threshold,
+		Window:    self.Window,
+		Cooldown:  self.Cooldown,
+	}
+}
+func (self *Breaker) Validate() error {
+	if self == nil {
+		// the breaker is optional
+		return nil
+	}
+	if self.Threshold <= 0 || self.Threshold > 1 {
+		return ERR_BREAKER_THRESHOLD
+	}
+	if self.Window < 1 {
+		return ERR_BREAKER_WINDOW
+	}
+	if self.Cooldown < 1 {
+		return ERR_BREAKER_COOLDOWN
+	}
+	return nil
+}
+
+type breaker struct {
+	// safe
+	config *Breaker
+	stats  *lagoonStats
+	// unsafe
+	state BreakerState
+	// ring of our most recent dials, true if failed
+	results  []bool
+	next     int
+	failures int
+	opened   time.Time
+	probing  bool
+	mu       sync.Mutex
+}
+
+func createBreaker(
+	config *Breaker,
+	stats *lagoonStats,
+) *breaker {
+	if config == nil {
+		return nil
+	}
+	return &breaker{
+		config:  config,
+		stats:   stats,
+		results: make([]bool, 0, config.Window),
+	}
+}
+func (self *breaker) getState() BreakerState {
+	if self == nil {
+		return BREAKER_CLOSED
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.state
+}
+func (self *breaker) allow() (
+	bool,
+	BreakerState,
+	bool,
+) {
+	// returns whether we may dial, and our new state if it changed
+	if self == nil {
+		return true, BREAKER_CLOSED, false
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	switch self.state {
+	case BREAKER_OPEN:
+		if time.Since(self.opened) < self.config.Cooldown {
+			break
+		}
+		// cooled down, we're the probe
+		self.state = BREAKER_HALF_OPEN
+		self.probing = true
+		return true, self.state, true
+	case BREAKER_HALF_OPEN:
+		if self.probing {
+			// only our probe may dial
+			break
+		}
+		self.probing = true
+		return true, self.state, false
+	default:
+		return true, self.state, false
+	}
+	atomic.AddInt64(&self.stats.breaker_rejections, 1)
+	return false, self.state, false
+}
+func (self *breaker) rejecting() bool {
+	// would a dial be rejected right now? unlike allow we never become the probe
+	if self == nil {
+		return false
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	rejecting := false
+	switch self.state {
+	case BREAKER_OPEN:
+		rejecting = time.Since(self.opened) < self.config.Cooldown
+	case BREAKER_HALF_OPEN:
+		rejecting = self.probing
+	}
+	if rejecting {
+		atomic.AddInt64(&self.stats.breaker_rejections, 1)
+	}
+	return rejecting
+}
+func (self *breaker) done(
+	failed bool,
+) (
+	BreakerState,
+	bool,
+) {
+	// record the result of a dial that we allowed, returns our new state if it changed
+	if self == nil {
+		return BREAKER_CLOSED, false
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.state == BREAKER_HALF_OPEN {
+		// our probe decides
+		self.probing = false
+		if failed {
+			self.open()
+		} else {
+			self.reset()
+		}
+		return self.state, true
+	}
+	if self.state == BREAKER_OPEN {
+		// a dial from before we opened
+		return self.state, false
+	}
+	if len(self.results) < self.config.Window {
+		self.results = append(self.results, failed)
+	} else {
+		if self.results[self.next] {
+			self.failures--
+		}
+		self.results[self.next] = failed
+		self.next = (self.next + 1) % self.config.Window
+	}
+	if failed {
+		self.failures++
+	}
+	if len(self.results) == self.config.Window && float64(self.failures) >= self.config.Threshold*float64(self.config.Window) {
+		self.open()
+		return self.state, true
+	}
+	return self.state, false
+}
+func (self *breaker) abort() {
+	// a dial that we allowed was abandoned by its caller, it says nothing about our backend
+	if self == nil {
+		return
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.state == BREAKER_HALF_OPEN {
+		// let somebody else probe
+		self.probing = false
+	}
+}
+func (self *breaker) open() {
+	// assumed that we're locked
+	self.state = BREAKER_OPEN
+	self.opened = time.Now()
+	atomic.AddInt64(&self.stats.breaker_opens, 1)
+}
+func (self *breaker) reset() {
+	// assumed that we're locked
+	self.state = BREAKER_CLOSED
+	self.results = self.results[:0]
+	self.next = 0
+	self.failures = 0
+}
+func (self *Lagoon) breakerAllow() bool {
+	// assumed that we're NOT locked
+	ok, state, changed := self.breaker.allow()
+	if changed {
+		self.onBreaker(state)
+	}
+	return ok
+}
+func (self *Lagoon) breakerDone(
+	err error,
+) {
+	// assumed that we're NOT locked
+	var e *ContextError
+	if errors.As(err, &e) {
+		self.breaker.abort()
+		return
+	}
+	if state, changed := self.breaker.done(err != nil); changed {
+		self.onBreaker(state)
+	}
+}
@@ -1,54 +1,267 @@
 package lagoon
 
 import (
-	"log"
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
 	"time"
 )
 
+var (
+	ERR_BUFFER_MAX     = fmt.Errorf("Buffer Max < 1")
+	ERR_BUFFER_TIMEOUT = fmt.Errorf("Buffer Timeout < 1")
+	ERR_BUFFER_FULL    = fmt.Errorf("Buffer Full")
+)
+
 type Buffer struct {
 	// safe
-	buffer  chan struct{}
+	max     int
 	timeout time.Duration
+	// unsafe
+	used    int
+	waiters *list.List
+	idlers  map[*Lagoon]struct{}
+	idled   chan struct{}
+	mu      sync.Mutex
 }
 
-func CreateBuffer(
+type bufferWaiter struct {
+	// safe
+	ready chan struct{}
+	// unsafe
+	element *list.Element
+	granted bool
+}
+
+func NewBuffer(
 	max int,
 	timeout time.Duration,
-) *Buffer {
+) (
+	*Buffer,
+	error,
+) {
 	if max <= 0 {
-		log.Fatalf("./lagoon.CreateBuffer(): max < 1: %d", max)
-		return nil
+		return nil, ERR_BUFFER_MAX
 	}
 	if timeout <= 0 {
-		log.Fatalf("./lagoon.CreateBuffer(): timeout < 1: %d", timeout)
-		return nil
+		return nil, ERR_BUFFER_TIMEOUT
 	}
 	return &Buffer{
-		buffer:  make(chan struct{}, max),
+		max:     max,
 		timeout: timeout,
-	}
+		waiters: list.New(),
+		idlers:  make(map[*Lagoon]struct{}),
+		idled:   make(chan struct{}),
+	}, nil
+}
+func CreateBuffer(
+	max int,
+	timeout time.Duration,
+) *Buffer {
+	// returns nil if max or timeout are invalid, use NewBuffer to find out why
+	// a nil buffer will fail Config.Validate
+	b, _ := NewBuffer(max, timeout)
+	return b
 }
 func (self *Buffer) GetMax() int {
-	return cap(self.buffer)
+	return self.max
 }
 func (self *Buffer) GetTimeout() time.Duration {
 	return self.timeout
 }
-func (self *Buffer) acquire() bool {
+func (self *Buffer) GetUsed() int {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.used
+}
+func (self *Buffer) GetWaiting() int {
+	// the amount of callers queued for a slot
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.waiters.Len()
+}
+func (self *Buffer) tryAcquire() bool {
+	// acquire without waiting
+	// BUFFER MUST BE RELEASED if true
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.used < self.max && self.waiters.Len() == 0 {
+		self.used++
+		return true
+	}
+	return false
+}
+func (self *Buffer) wait() *bufferWaiter {
+	w := &bufferWaiter{
+		ready: make(chan struct{}),
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.used < self.max && self.waiters.Len() == 0 {
+		// slot is free and nobody is ahead of us
+		self.used++
+		w.granted = true
+		close(w.ready)
+		return w
+	}
+	// wait in line
+	w.element = self.waiters.PushBack(w)
+	return w
+}
+func (self *Buffer) cancel(
+	w *bufferWaiter,
+) bool {
+	// stop waiting
+	// returns true if the slot was granted before we could cancel
+	// a granted slot MUST BE RELEASED
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if w.granted {
+		return true
+	}
+	self.waiters.Remove(w.element)
+	w.element = nil
+	return false
+}
+func (self *Buffer) acquire(
+	ctx context.Context,
+	from *Lagoon,
+) error {
 	// acquire from the buffer
+	// BUFFER MUST BE RELEASED if nil
+	_, err := self.await(ctx, from, nil)
+	return err
+}
+func (self *Buffer) await(
+	ctx context.Context,
+	from *Lagoon,
+	handoff <-chan *Connection,
+) (
+	*Connection,
+	error,
+) {
+	// wait for a slot, or for a connection to be handed off to us, whichever comes first
+	// slots are handed to waiters in the order they arrived
+	// idle slots held by other lagoons are stolen while we wait
+	// returns a handed off connection without a slot
+	// BUFFER MUST BE RELEASED if both are nil
+	idled := self.stealable()
+	w := self.wait()
 	select {
-	// this will block when the buffer becomes full
-	case self.buffer <- struct{}{}:
+	case <-w.ready:
 		// successfully acquired!
-		// BUFFER MUST BE RELEASED
-		return true
-	case <-time.After(self.timeout):
-		// failed to acquire
-		// buffer will not have to be released!!!
-		return false
+		return nil, nil
+	default:
+		// buffer is full, free a slot held by an idle connection in another lagoon
+		self.steal(from, false)
+	}
+	timer := time.NewTimer(self.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case c, ok := <-handoff:
+			// a returned connection was handed to us, it's already active
+			if self.cancel(w) {
+				// we don't need our slot, pass it on
+				self.release()
+			}
+			if !ok {
+				// our lagoon was closed while waiting
+				return nil, &PoolError{ErrPoolClosed}
+			}
+			return c, nil
+		// this will block when the buffer becomes full
+		case <-w.ready:
+			// successfully acquired!
+			return nil, nil
+		case <-idled:
+			// another lagoon started idling while we waited, try stealing again
+			idled = self.stealable()
+			self.steal(from, false)
+		case <-timer.C:
+			if self.cancel(w) {
+				// we were granted a slot as we timed out
+				return nil, nil
+			}
+			// failed to acquire
+			// buffer will not have to be released!!!
+			return nil, &PoolError{ERR_TIMEDOUT}
+		case <-ctx.Done():
+			if self.cancel(w) {
+				// we were granted a slot as we gave up, pass it on
+				self.release()
+			}
+			// caller gave up
+			// buffer will not have to be released!!!
+			return nil, &ContextError{ctx.Err()}
+		}
 	}
 }
 func (self *Buffer) release() {
 	// release from the buffer
-	<-self.buffer
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if e := self.waiters.Front(); e != nil {
+		// hand our slot directly to the oldest waiter
+		w := self.waiters.Remove(e).(*bufferWaiter)
+		w.element = nil
+		w.granted = true
+		close(w.ready)
+		return
+	}
+	self.used--
+}
+func (self *Buffer) idling(
+	l *Lagoon,
+	idle bool,
+) {
+	// track which lagoons are holding slots with idle connections
+	self.mu.Lock()
+	if idle {
+		self.idlers[l] = struct{}{}
+		if self.waiters.Len() > 0 {
+			// wake our waiters, they may steal from this lagoon
+			close(self.idled)
+			self.idled = make(chan struct{})
+		}
+	} else {
+		delete(self.idlers, l)
+	}
+	self.mu.Unlock()
+}
+func (self *Buffer) stealable() <-chan struct{} {
+	// closed when another lagoon starts idling while callers are waiting
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.idled
+}
+func (self *Buffer) steal(
+	from *Lagoon,
+	spare bool,
+) bool {
+	// assumed that no lagoon is locked
+	// evict the oldest idle connection held by another lagoon
+	// if spare, only connections beyond another lagoon's MinIdle are stolen
+	// the freed slot is handed to our oldest waiter
+	self.mu.Lock()
+	idlers := make([]*Lagoon, 0, len(self.idlers))
+	for l, _ := range self.idlers {
+		if l != from {
+			idlers = append(idlers, l)
+		}
+	}
+	self.mu.Unlock()
+	var oldest *Lagoon
+	var oldest_idle time.Time
+	for _, l := range idlers {
+		if idle, ok := l.oldestIdle(spare); ok && (oldest == nil || idle.Before(oldest_idle)) {
+			oldest, oldest_idle = l, idle
+		}
+	}
+	if oldest == nil {
+		// nothing to steal
+		return false
+	}
+	return oldest.evictIdle(spare)
 }
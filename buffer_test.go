@@ -0,0 +1,198 @@
+package lagoon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sabey.co/unittest"
+	"testing"
+	"time"
+)
+
+func TestNewBuffer(t *testing.T) {
+	log.Println("TestNewBuffer")
+
+	buffer, err := NewBuffer(0, time.Second)
+	unittest.IsNil(t, buffer)
+	unittest.Equals(t, err, ERR_BUFFER_MAX)
+
+	buffer, err = NewBuffer(1, 0)
+	unittest.IsNil(t, buffer)
+	unittest.Equals(t, err, ERR_BUFFER_TIMEOUT)
+
+	// invalid buffers must never exit our process
+	unittest.IsNil(t, CreateBuffer(0, 0))
+	_, err = CreateLagoon(&Config{
+		Dial: func() (net.Conn, error) {
+			return &fakeConnection{}, nil
+		},
+		Buffer: CreateBuffer(0, 0),
+	})
+	unittest.Equals(t, err, ERR_DIAL_BUFFER_NIL)
+
+	buffer, err = NewBuffer(1, time.Second)
+	unittest.IsNil(t, err)
+	unittest.Equals(t, buffer.GetMax(), 1)
+	unittest.Equals(t, buffer.GetTimeout(), time.Second)
+}
+func TestBufferFIFO(t *testing.T) {
+	log.Println("TestBufferFIFO")
+
+	buffer := CreateBuffer(1, time.Second*5)
+	unittest.NotNil(t, buffer)
+
+	// take the only slot
+	unittest.IsNil(t, buffer.acquire(context.Background(), nil))
+	unittest.Equals(t, buffer.GetUsed(), 1)
+	unittest.Equals(t, buffer.GetWaiting(), 0)
+
+	// queue waiters in order
+	fmt.Println("queue waiters")
+	order := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			if buffer.acquire(context.Background(), nil) == nil {
+				order <- i
+			}
+		}(i)
+		for buffer.GetWaiting() != i+1 {
+			<-time.After(time.Millisecond)
+		}
+	}
+
+	// slots must be handed out in arrival order
+	fmt.Println("release in order")
+	for i := 0; i < 3; i++ {
+		buffer.release()
+		unittest.Equals(t, <-order, i)
+		unittest.Equals(t, buffer.GetWaiting(), 2-i)
+		unittest.Equals(t, buffer.GetUsed(), 1)
+	}
+	buffer.release()
+	unittest.Equals(t, buffer.GetUsed(), 0)
+}
+func TestBufferCancel(t *testing.T) {
+	log.Println("TestBufferCancel")
+
+	buffer := CreateBuffer(1, time.Millisecond*50)
+	unittest.NotNil(t, buffer)
+
+	unittest.IsNil(t, buffer.acquire(context.Background(), nil))
+
+	// timed out waiters leave the queue
+	fmt.Println("timeout")
+	err := buffer.acquire(context.Background(), nil)
+	unittest.NotNil(t, err)
+	unittest.Equals(t, err.(*PoolError).Err, ERR_TIMEDOUT)
+	unittest.Equals(t, buffer.GetWaiting(), 0)
+
+	// cancelled waiters leave the queue
+	fmt.Println("cancel")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = buffer.acquire(ctx, nil)
+	unittest.NotNil(t, err)
+	unittest.Equals(t, err.(*ContextError).Err, context.Canceled)
+	unittest.Equals(t, buffer.GetWaiting(), 0)
+
+	buffer.release()
+	unittest.Equals(t, buffer.GetUsed(), 0)
+}
+func TestBufferSteal(t *testing.T) {
+	log.Println("TestBufferSteal")
+
+	buffer := CreateBuffer(1, time.Second*5)
+	unittest.NotNil(t, buffer)
+
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			return &fakeConnection{}, nil
+		},
+		Buffer: buffer,
+	}
+
+	a, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	b, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+
+	// a holds our only slot with an idle connection
+	unittest.IsNil(t, a.DialInitialize())
+	unittest.Equals(t, a.ConnectionsAvailable(), 1)
+	unittest.Equals(t, buffer.GetUsed(), 1)
+
+	// b steals the idle slot instead of timing out
+	fmt.Println("steal")
+	started := time.Now()
+	c, err := b.Dial()
+	unittest.IsNil(t, err)
+	unittest.Equals(t, time.Since(started) < time.Second, true)
+	unittest.Equals(t, a.Connections(), 0)
+	unittest.Equals(t, a.Stats().Steals, int64(1))
+	unittest.Equals(t, b.ConnectionsActive(), 1)
+	unittest.Equals(t, buffer.GetUsed(), 1)
+
+	// active connections are never stolen
+	fmt.Println("nothing to steal")
+	buffer.timeout = time.Millisecond * 50
+	_, err = a.Dial()
+	unittest.NotNil(t, err)
+	unittest.Equals(t, b.ConnectionsActive(), 1)
+
+	unittest.IsNil(t, c.Close())
+	b.Close()
+	unittest.Equals(t, buffer.GetUsed(), 0)
+}
+func TestBufferStealWaiting(t *testing.T) {
+	log.Println("TestBufferStealWaiting")
+
+	buffer := CreateBuffer(1, time.Second*5)
+	unittest.NotNil(t, buffer)
+
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			return &fakeConnection{}, nil
+		},
+		Buffer: buffer,
+	}
+
+	a, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	b, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+
+	for _, dial := range []func() (net.Conn, error){
+		b.Dial,
+		func() (net.Conn, error) {
+			return nil, b.DialInitialize()
+		},
+	} {
+		// a holds our only slot with an active connection, there's nothing to steal yet
+		fmt.Println("idle while waiting")
+		c, err := a.Dial()
+		unittest.IsNil(t, err)
+		dialed := make(chan error, 1)
+		go func() {
+			_, err := dial()
+			dialed <- err
+		}()
+		for buffer.GetWaiting() != 1 {
+			<-time.After(time.Millisecond)
+		}
+		// returning our connection makes it stealable
+		started := time.Now()
+		unittest.IsNil(t, c.Close())
+		unittest.IsNil(t, <-dialed)
+		unittest.Equals(t, time.Since(started) < time.Second, true)
+		unittest.Equals(t, a.Connections(), 0)
+		unittest.Equals(t, b.Connections(), 1)
+		unittest.Equals(t, buffer.GetUsed(), 1)
+		b.CloseActive()
+		b.CloseAvailable()
+		unittest.Equals(t, buffer.GetUsed(), 0)
+	}
+	unittest.Equals(t, a.Stats().Steals, int64(2))
+	a.Close()
+	b.Close()
+}
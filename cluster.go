@@ -0,0 +1,249 @@
+package lagoon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ERR_CLUSTER_CONFIG_NIL = fmt.Errorf("Cluster Config NIL")
+	ERR_CLUSTER_DIAL_NIL   = fmt.Errorf("Cluster Dial NIL")
+	ERR_CLUSTER_TTL        = fmt.Errorf("Cluster TTL < 0")
+	ERR_CLUSTER_CLOSED     = fmt.Errorf("Cluster Closed")
+)
+
+type ClusterConfig struct {
+	// dial a connection for a key, usually host:port
+	Dial func(ctx context.Context, key string) (net.Conn, error)
+	// template for every pool, Dial and DialContext are replaced
+	// Config.Buffer is shared by every pool
+	Config *Config
+	// pools that haven't been used for TTL are closed and removed
+	// 0 will keep pools forever
+	TTL time.Duration
+}
+
+type Cluster struct {
+	// safe
+	config *ClusterConfig
+	stop   chan struct{}
+	// unsafe
+	lagoons map[string]*clusterLagoon
+	closed  bool
+	mu      sync.Mutex
+}
+
+type clusterLagoon struct {
+	// safe
+	l *Lagoon
+	// unsafe
+	// protected by the cluster
+	used    time.Time
+	dialing int
+}
+
+func (self *ClusterConfig) Validate() error {
+	if self == nil {
+		return ERR_CLUSTER_CONFIG_NIL
+	}
+	if self.Dial == nil {
+		return ERR_CLUSTER_DIAL_NIL
+	}
+	if self.Config == nil {
+		return ERR_CONFIG_NIL
+	}
+	if self.TTL < 0 {
+		return ERR_CLUSTER_TTL
+	}
+	return nil
+}
+func CreateCluster(
+	config *ClusterConfig,
+) (
+	*Cluster,
+	error,
+) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	// dereference
+	config = &ClusterConfig{
+		Dial:   config.Dial,
+		Config: config.Config.Clone(),
+		TTL:    config.TTL,
+	}
+	// validate our template with a placeholder dial
+	template := config.Config.Clone()
+	template.DialContext = func(ctx context.Context) (net.Conn, error) {
+		return nil, nil
+	}
+	if err := template.Validate(); err != nil {
+		return nil, err
+	}
+	cl := &Cluster{
+		config:  config,
+		stop:    make(chan struct{}),
+		lagoons: make(map[string]*clusterLagoon),
+	}
+	if config.TTL > 0 {
+		go cl.evict()
+	}
+	return cl, nil
+}
+func (self *Cluster) IsValid() bool {
+	if self == nil {
+		return false
+	}
+	return true
+}
+func (self *Cluster) lagoon(
+	key string,
+) (
+	*clusterLagoon,
+	error,
+) {
+	// assumed that we're locked
+	if self.closed {
+		return nil, ERR_CLUSTER_CLOSED
+	}
+	if cl, ok := self.lagoons[key]; ok {
+		return cl, nil
+	}
+	// lazily create a pool for our key
+	config := self.config.Config.Clone()
+	config.Dial = nil
+	config.DialContext = func(ctx context.Context) (net.Conn, error) {
+		return self.config.Dial(ctx, key)
+	}
+	// we can't block every other key while we dial initial connections
+	config.DialInitial = 0
+	l, err := CreateLagoon(config)
+	if err != nil {
+		return nil, err
+	}
+	cl := &clusterLagoon{
+		l:    l,
+		used: time.Now(),
+	}
+	self.lagoons[key] = cl
+	if self.config.Config.DialInitial > 0 {
+		// warm our new pool in the background
+		for i := 0; i < self.config.Config.DialInitial; i++ {
+			go l.DialInitialize()
+		}
+	}
+	return cl, nil
+}
+func (self *Cluster) Lagoon(
+	key string,
+) (
+	*Lagoon,
+	error,
+) {
+	// get or create the pool for a key
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	cl, err := self.lagoon(key)
+	if err != nil {
+		return nil, err
+	}
+	cl.used = time.Now()
+	return cl.l, nil
+}
+func (self *Cluster) DialKey(
+	key string,
+) (
+	net.Conn,
+	error,
+) {
+	return self.DialKeyContext(context.Background(), key)
+}
+func (self *Cluster) DialKeyContext(
+	ctx context.Context,
+	key string,
+) (
+	net.Conn,
+	error,
+) {
+	self.mu.Lock()
+	cl, err := self.lagoon(key)
+	if err != nil {
+		self.mu.Unlock()
+		return nil, err
+	}
+	// our pool can't be evicted while we're dialing
+	cl.used = time.Now()
+	cl.dialing++
+	self.mu.Unlock()
+	c, err := cl.l.DialContext(ctx)
+	self.mu.Lock()
+	cl.dialing--
+	cl.used = time.Now()
+	self.mu.Unlock()
+	return c, err
+}
+func (self *Cluster) Keys() []string {
+	self.mu.Lock()
+	keys := make([]string, 0, len(self.lagoons))
+	for key, _ := range self.lagoons {
+		keys = append(keys, key)
+	}
+	self.mu.Unlock()
+	sort.Strings(keys)
+	return keys
+}
+func (self *Cluster) evict() {
+	every := self.config.TTL / 2
+	if every < time.Millisecond {
+		every = time.Millisecond
+	}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-self.stop:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		var closing []*Lagoon
+		self.mu.Lock()
+		for key, cl := range self.lagoons {
+			if cl.dialing > 0 || now.Sub(cl.used) < self.config.TTL {
+				continue
+			}
+			if cl.l.ConnectionsActive() > 0 {
+				// still in use, connections will be returned to this pool
+				cl.used = now
+				continue
+			}
+			delete(self.lagoons, key)
+			closing = append(closing, cl.l)
+		}
+		self.mu.Unlock()
+		// close outside of our lock
+		for _, l := range closing {
+			l.Close()
+		}
+	}
+}
+func (self *Cluster) Close() {
+	// close every pool, the cluster will no longer be usable
+	self.mu.Lock()
+	if self.closed {
+		self.mu.Unlock()
+		return
+	}
+	self.closed = true
+	close(self.stop)
+	lagoons := self.lagoons
+	self.lagoons = make(map[string]*clusterLagoon)
+	self.mu.Unlock()
+	for _, cl := range lagoons {
+		cl.l.Close()
+	}
+}
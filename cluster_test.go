@@ -0,0 +1,73 @@
+package lagoon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sabey.co/unittest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCluster(t *testing.T) {
+	log.Println("TestCluster")
+
+	buffer := CreateBuffer(3, time.Millisecond*100)
+	unittest.NotNil(t, buffer)
+
+	var mu sync.Mutex
+	dialed := make(map[string]int)
+	config := &ClusterConfig{
+		Dial: func(ctx context.Context, key string) (net.Conn, error) {
+			mu.Lock()
+			dialed[key]++
+			mu.Unlock()
+			return &fakeConnection{}, nil
+		},
+		Config: &Config{
+			Buffer: buffer,
+		},
+		TTL: time.Millisecond * 100,
+	}
+
+	cl, err := CreateCluster(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, cl)
+
+	// pools are created lazily
+	fmt.Println("dial keys")
+	a, err := cl.DialKey("a:25")
+	unittest.IsNil(t, err)
+	b, err := cl.DialKey("b:25")
+	unittest.IsNil(t, err)
+	_, err = cl.DialKey("b:25")
+	unittest.IsNil(t, err)
+	unittest.Equals(t, cl.Keys(), []string{"a:25", "b:25"})
+	mu.Lock()
+	unittest.Equals(t, dialed["a:25"], 1)
+	unittest.Equals(t, dialed["b:25"], 2)
+	mu.Unlock()
+
+	// every pool shares our buffer
+	fmt.Println("shared buffer")
+	unittest.Equals(t, buffer.GetUsed(), 3)
+	_, err = cl.DialKey("c:25")
+	unittest.NotNil(t, err)
+
+	// unused pools are evicted once idle
+	fmt.Println("evict")
+	unittest.IsNil(t, a.Close())
+	<-time.After(time.Millisecond * 300)
+	unittest.Equals(t, cl.Keys(), []string{"b:25"})
+	unittest.Equals(t, buffer.GetUsed(), 2)
+	unittest.IsNil(t, b.Close())
+
+	fmt.Println("close")
+	cl.Close()
+	unittest.Equals(t, cl.Keys(), []string{})
+	unittest.Equals(t, buffer.GetUsed(), 0)
+	_, err = cl.DialKey("a:25")
+	unittest.Equals(t, err, ERR_CLUSTER_CLOSED)
+}
@@ -1,6 +1,7 @@
 package lagoon
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"time"
@@ -10,6 +11,11 @@ const (
 	TICKEVERY_MIN     = time.Second * 5
 	TICKEVERY_DEFAULT = time.Second * 15
 	TICKEVERY_MAX     = time.Minute
+	// refill backs off between these after failing to dial
+	REFILL_BACKOFF_MIN = time.Millisecond * 100
+	REFILL_BACKOFF_MAX = time.Second * 30
+	// refill retries after this while our buffer is full
+	REFILL_BUFFER_DELAY = time.Millisecond * 25
 )
 
 var (
@@ -18,14 +24,63 @@ var (
 	ERR_DIAL_INITIAL            = fmt.Errorf("Dial Initial < 0")
 	ERR_DIAL_BUFFER_NIL         = fmt.Errorf("Dial Buffer NIL")
 	ERR_DIAL_INITIAL_BUFFER_MAX = fmt.Errorf("Dial Initial More Than Buffer Max")
+	ERR_TEST_IDLE_AFTER         = fmt.Errorf("Test Idle After < 0")
+	ERR_MAX_LIFETIME            = fmt.Errorf("Max Lifetime < 0")
+	ERR_MAX_LIFETIME_JITTER     = fmt.Errorf("Max Lifetime Jitter < 0 Or More Than Max Lifetime")
+	ERR_MAX_USES                = fmt.Errorf("Max Uses < 0")
+	ERR_MIN_IDLE                = fmt.Errorf("Min Idle < 0")
+	ERR_MIN_IDLE_BUFFER_MAX     = fmt.Errorf("Min Idle More Than Buffer Max")
+	ERR_SELECTION               = fmt.Errorf("Selection Unknown")
+	ERR_LEAK_THRESHOLD          = fmt.Errorf("Leak Threshold < 0")
+	ERR_MAX_CHECKOUT_DURATION   = fmt.Errorf("Max Checkout Duration < 0")
 )
 
 type Config struct {
 	Dial        func() (net.Conn, error)
+	DialContext func(ctx context.Context) (net.Conn, error)
 	DialInitial int
+	// connections are dialed in the background to keep at least MinIdle available
+	MinIdle     int
 	IdleTimeout time.Duration
-	TickEvery   time.Duration
-	Buffer      *Buffer
+	// deprecated: connections now expire at their exact deadline
+	TickEvery time.Duration
+	Buffer    *Buffer
+	// which available connection Dial will hand out
+	Selection Selection
+	// health checks, a failing connection is disabled and removed from the lagoon
+	TestOnBorrow func(*Connection) error
+	TestOnReturn func(*Connection) error
+	// skip TestOnBorrow for connections that have been idle for less than TestIdleAfter
+	TestIdleAfter time.Duration
+	// connections are closed once they've outlived MaxLifetime or have been checked out MaxUses times
+	// each connection's lifetime is shortened by a random amount up to MaxLifetimeJitter
+	MaxLifetime       time.Duration
+	MaxLifetimeJitter time.Duration
+	MaxUses           int
+	// failed dials are retried with exponential backoff before Dial gives up, nil never retries
+	Retry *Retry
+	// dials fail fast with ErrCircuitOpen while our backend is failing, nil never opens
+	Breaker *Breaker
+	// debugging, connections checked out for longer than LeakThreshold are reported by OnLeak and Lagoon.Leaks
+	// the stack of every Dial is recorded, 0 disables
+	LeakThreshold time.Duration
+	// connections checked out for longer than MaxCheckoutDuration are disabled and closed, releasing their buffer slot
+	// the owner's next Read or Write fails with ErrReclaimed, 0 never reclaims
+	MaxCheckoutDuration time.Duration
+	// decides whether a Read or Write error leaves a connection unusable, broken connections are disabled
+	// nil uses IsBroken
+	Broken func(error) bool
+	// hooks are never called while the lagoon is locked
+	// an error from OnDial fails the dial, allowing per connection setup such as authentication
+	OnDial      func(*Connection) error
+	OnDialError func(error)
+	OnCheckout  func(*Connection)
+	OnReturn    func(*Connection)
+	OnDisable   func(*Connection)
+	OnIdleEvict func(*Connection)
+	OnClose     func(*Connection, error)
+	OnBreaker   func(BreakerState)
+	OnLeak      func(*Connection)
 }
 
 func (self *Config) IsValid() bool {
@@ -40,10 +95,38 @@ func (self *Config) Clone() *Config {
 	}
 	config := &Config{
 		Dial:        self.Dial,
+		DialContext: self.DialContext,
 		DialInitial: self.DialInitial,
+		MinIdle:     self.MinIdle,
 		IdleTimeout: self.IdleTimeout,
 		TickEvery:   self.TickEvery,
 		Buffer:      self.Buffer,
+		Selection:   self.Selection,
+		// health checks
+		TestOnBorrow:  self.TestOnBorrow,
+		TestOnReturn:  self.TestOnReturn,
+		TestIdleAfter: self.TestIdleAfter,
+		// recycling
+		MaxLifetime:       self.MaxLifetime,
+		MaxLifetimeJitter: self.MaxLifetimeJitter,
+		MaxUses:           self.MaxUses,
+		// failing dials
+		Retry:   self.Retry.Clone(),
+		Breaker: self.Breaker.Clone(),
+		// held connections
+		LeakThreshold:       self.LeakThreshold,
+		MaxCheckoutDuration: self.MaxCheckoutDuration,
+		Broken:              self.Broken,
+		// hooks
+		OnDial:      self.OnDial,
+		OnDialError: self.OnDialError,
+		OnCheckout:  self.OnCheckout,
+		OnReturn:    self.OnReturn,
+		OnDisable:   self.OnDisable,
+		OnIdleEvict: self.OnIdleEvict,
+		OnClose:     self.OnClose,
+		OnBreaker:   self.OnBreaker,
+		OnLeak:      self.OnLeak,
 	}
 	return config
 }
@@ -51,7 +134,7 @@ func (self *Config) Validate() error {
 	if self == nil {
 		return ERR_CONFIG_NIL
 	}
-	if self.Dial == nil {
+	if self.Dial == nil && self.DialContext == nil {
 		return ERR_DIAL_NIL
 	}
 	if self.DialInitial < 0 {
@@ -63,6 +146,39 @@ func (self *Config) Validate() error {
 	if self.DialInitial > self.Buffer.GetMax() {
 		return ERR_DIAL_INITIAL_BUFFER_MAX
 	}
+	if self.MinIdle < 0 {
+		return ERR_MIN_IDLE
+	}
+	if self.MinIdle > self.Buffer.GetMax() {
+		return ERR_MIN_IDLE_BUFFER_MAX
+	}
+	if !self.Selection.IsValid() {
+		return ERR_SELECTION
+	}
+	if self.TestIdleAfter < 0 {
+		return ERR_TEST_IDLE_AFTER
+	}
+	if self.MaxLifetime < 0 {
+		return ERR_MAX_LIFETIME
+	}
+	if self.MaxLifetimeJitter < 0 || self.MaxLifetimeJitter > self.MaxLifetime {
+		return ERR_MAX_LIFETIME_JITTER
+	}
+	if self.MaxUses < 0 {
+		return ERR_MAX_USES
+	}
+	if self.LeakThreshold < 0 {
+		return ERR_LEAK_THRESHOLD
+	}
+	if self.MaxCheckoutDuration < 0 {
+		return ERR_MAX_CHECKOUT_DURATION
+	}
+	if err := self.Retry.Validate(); err != nil {
+		return err
+	}
+	if err := self.Breaker.Validate(); err != nil {
+		return err
+	}
 	if self.TickEvery == 0 {
 		self.TickEvery = TICKEVERY_DEFAULT
 	} else if self.TickEvery < TICKEVERY_MIN {
@@ -1,19 +1,48 @@
 package lagoon
 
 import (
+	"math/rand"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// every Dial hands out a distinct Connection on a pooled connection
+// once closed a Connection can never touch the pooled connection again, it may already belong to another caller
 type Connection struct {
+	// safe
+	net.Conn
+	c *pooledConn
+}
+
+type pooledConn struct {
 	// safe
 	l *Lagoon
+	// must only be accessed atomically
+	reclaimed int32
+	// the Connection we're currently checked out by, nil while available
+	lent atomic.Value
 	// unsafe
 	net.Conn
+	// the Connection of our latest checkout, it's what our hooks see
+	handle   *Connection
 	disabled bool
+	// removed and closed by the lagoon while checked out, our owner's Close must not close us again
+	yanked   bool
 	idle     time.Time
-	mu       sync.Mutex
+	created  time.Time
+	expires  time.Time
+	uses     int
+	deadline time.Time
+	index    int
+	// recorded while checked out if Config.LeakThreshold is set
+	checkout   time.Time
+	stack      []byte
+	leak_timer *time.Timer
+	// taken back from our owner after Config.MaxCheckoutDuration
+	reclaim_timer *time.Timer
+	mu            sync.Mutex
 }
 
 func (self *Connection) IsValid() bool {
@@ -24,67 +53,294 @@ func (self *Connection) IsValid() bool {
 }
 func (self *Lagoon) createConnection(
 	conn net.Conn,
-) *Connection {
-	return &Connection{
-		l:    self,
+) *pooledConn {
+	now := time.Now()
+	c := &pooledConn{
+		l:       self,
+		Conn:    conn,
+		idle:    now,
+		created: now,
+		// not in our expiry heap
+		index: -1,
+	}
+	// OnDial sees a Connection that was never checked out
+	c.handle = &Connection{
 		Conn: conn,
-		idle: time.Now(),
+		c:    c,
+	}
+	c.lent.Store((*Connection)(nil))
+	if self.config.MaxLifetime > 0 {
+		// jitter shortens our lifetime so that connections dialed together don't expire together
+		lifetime := self.config.MaxLifetime
+		if self.config.MaxLifetimeJitter > 0 {
+			lifetime -= time.Duration(rand.Int63n(int64(self.config.MaxLifetimeJitter)))
+		}
+		c.expires = now.Add(lifetime)
 	}
+	return c
+}
+func (self *pooledConn) lend() *Connection {
+	// assumed that parent is locked
+	// a distinct Connection for every checkout
+	self.handle = &Connection{
+		Conn: self.Conn,
+		c:    self,
+	}
+	self.lent.Store(self.handle)
+	return self.handle
+}
+func (self *Connection) isLent() bool {
+	// is this Connection still checked out?
+	c, _ := self.c.lent.Load().(*Connection)
+	return c == self
 }
 func (self *Connection) Disable() {
+	// remove from the lagoon on close
+	if !self.isLent() {
+		// returned, we no longer own this connection
+		return
+	}
+	if self.c.disable() {
+		self.c.l.onDisable(self)
+	}
+}
+func (self *pooledConn) disable() bool {
+	// returns true if we weren't already disabled
 	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.disabled {
+		return false
+	}
+	atomic.AddInt64(&self.l.stats.disables, 1)
 	self.disabled = true
-	self.mu.Unlock()
+	return true
+}
+func (self *Connection) Read(
+	b []byte,
+) (
+	int,
+	error,
+) {
+	if !self.isLent() {
+		return 0, &PoolError{ErrConnReturned}
+	}
+	if self.c.isReclaimed() {
+		return 0, &PoolError{ErrReclaimed}
+	}
+	n, err := self.Conn.Read(b)
+	return n, self.failed(err)
+}
+func (self *Connection) Write(
+	b []byte,
+) (
+	int,
+	error,
+) {
+	if !self.isLent() {
+		return 0, &PoolError{ErrConnReturned}
+	}
+	if self.c.isReclaimed() {
+		return 0, &PoolError{ErrReclaimed}
+	}
+	n, err := self.Conn.Write(b)
+	return n, self.failed(err)
+}
+func (self *Connection) failed(
+	err error,
+) error {
+	// a broken connection must never be returned to available
+	if err == nil {
+		return nil
+	}
+	if self.c.isReclaimed() {
+		// we were reclaimed while reading or writing
+		return &PoolError{ErrReclaimed}
+	}
+	broken := IsBroken
+	if self.c.l.config.Broken != nil {
+		broken = self.c.l.config.Broken
+	}
+	if broken(err) {
+		self.Disable()
+	}
+	return err
+}
+func (self *Connection) SetDeadline(
+	t time.Time,
+) error {
+	if !self.isLent() {
+		return &PoolError{ErrConnReturned}
+	}
+	return self.Conn.SetDeadline(t)
+}
+func (self *Connection) SetReadDeadline(
+	t time.Time,
+) error {
+	if !self.isLent() {
+		return &PoolError{ErrConnReturned}
+	}
+	return self.Conn.SetReadDeadline(t)
+}
+func (self *Connection) SetWriteDeadline(
+	t time.Time,
+) error {
+	if !self.isLent() {
+		return &PoolError{ErrConnReturned}
+	}
+	return self.Conn.SetWriteDeadline(t)
 }
 func (self *Connection) Close() error {
+	// return to the lagoon, a second Close fails with ErrConnReturned
+	if !self.isLent() {
+		return &PoolError{ErrConnReturned}
+	}
+	return self.c.close(self)
+}
+func (self *pooledConn) close(
+	h *Connection,
+) error {
+	// h is the Connection being returned, nil if the lagoon is closing us directly
+	hooked := h
+	if hooked == nil {
+		hooked = self.handle
+	}
+	var unhealthy error
+	if self.l.config.TestOnReturn != nil && self.returning() {
+		if err := self.l.config.TestOnReturn(hooked); err != nil {
+			// unhealthy - do not return to available
+			unhealthy = &HealthCheckError{err}
+			if self.disable() {
+				self.l.onDisable(hooked)
+			}
+		}
+	}
 	// lock parent
 	self.l.mu.Lock()
-	defer self.l.mu.Unlock()
-	return self.close()
-}
-func (self *Connection) close() error {
 	// lock self
 	self.mu.Lock()
+	if h != nil && !h.isLent() {
+		// closed twice at once, the other Close won
+		self.mu.Unlock()
+		self.l.unlock()
+		return &PoolError{ErrConnReturned}
+	}
+	if self.yanked {
+		// the lagoon already closed us and released our buffer slot
+		if h != nil {
+			// our owner is done with us, a second Close fails with ErrConnReturned
+			self.lent.Store((*Connection)(nil))
+		}
+		self.mu.Unlock()
+		self.l.unlock()
+		if self.isReclaimed() {
+			return &PoolError{ErrReclaimed}
+		}
+		return nil
+	}
+	// our owner is done with us, before we can be handed to somebody else
+	self.lent.Store((*Connection)(nil))
+	closing, release := self.remove()
+	self.mu.Unlock()
+	self.l.unlock()
+	if !closing {
+		// returned to available
+		self.l.onReturn(hooked)
+		return nil
+	}
+	// close outside of our locks
+	if err := self.discard(release); err != nil {
+		return err
+	}
+	if unhealthy != nil {
+		// let our caller know why we weren't returned to available
+		return unhealthy
+	}
+	return nil
+}
+func (self *pooledConn) expired(
+	now time.Time,
+) bool {
+	// assumed that parent is locked
+	if !self.expires.IsZero() && now.After(self.expires) {
+		// outlived MaxLifetime
+		return true
+	}
+	if self.l.config.MaxUses > 0 && self.uses >= self.l.config.MaxUses {
+		// used up
+		return true
+	}
+	return false
+}
+func (self *pooledConn) returning() bool {
+	// will this connection be returned to available on close?
+	self.l.mu.RLock()
+	_, ok := self.l.active[self]
+	self.l.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	self.mu.Lock()
 	defer self.mu.Unlock()
-	return self.remove()
+	return !self.disabled
 }
-func (self *Connection) remove() error {
+func (self *pooledConn) remove() (
+	closing bool,
+	release bool,
+) {
 	// assumed that parent and self are both locked
-	var err error
+	// only bookkeeping happens here, the caller closes once unlocked
 	// check if connection is in active
 	if _, ok := self.l.active[self]; ok {
 		// remove from active
 		delete(self.l.active, self)
-		if self.disabled {
+		self.returned()
+		self.l.drain()
+		if self.disabled || self.l.closed || self.expired(time.Now()) {
+			if !self.disabled && !self.l.closed {
+				atomic.AddInt64(&self.l.stats.expirations, 1)
+			}
 			// close connection
-			err = self.Conn.Close()
 			// release buffer
-			self.l.config.Buffer.release()
+			closing, release = true, true
 		} else {
-			// return to available
-			self.idle = time.Now()
-			self.l.available[self] = struct{}{}
+			// return to available, or hand to a waiting caller
+			self.l.put(self)
 			// DO NOT RELEASE BUFFER!!!
 		}
 	} else {
 		// check if connection is in available
-		if _, ok := self.l.available[self]; ok {
-			// remove from available
-			delete(self.l.available, self)
+		if self.l.removeAvailable(self) {
+			// removed from available
 			// close connection
-			err = self.Conn.Close()
 			// release buffer
-			self.l.config.Buffer.release()
+			closing, release = true, true
 		} else {
-			// not found, wtf?
+			// not found
+			if !self.disabled {
+				// the lagoon disables everything it removes, this should never happen
+				atomic.AddInt64(&self.l.stats.invariants, 1)
+			}
 			// close connection
-			err = self.Conn.Close()
 			// DO NOT RELEASE BUFFER!!!
+			closing = true
 		}
 	}
 	// toggle tick
 	self.l.toggleTick()
-	// closed
+	return closing, release
+}
+func (self *pooledConn) discard(
+	release bool,
+) error {
+	// assumed that parent is NOT locked
+	// close connection
+	err := self.Conn.Close()
+	atomic.AddInt64(&self.l.stats.closes, 1)
+	if release {
+		// release buffer
+		self.l.config.Buffer.release()
+	}
+	self.l.onClose(self.handle, err)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,175 @@
+package lagoon
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sabey.co/unittest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type failingConnection struct {
+	fakeConnection
+	mu  sync.Mutex
+	err error
+}
+
+func (self *failingConnection) fail(err error) {
+	self.mu.Lock()
+	self.err = err
+	self.mu.Unlock()
+}
+func (self *failingConnection) Read(b []byte) (n int, err error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return 0, self.err
+}
+func (self *failingConnection) Write(b []byte) (n int, err error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return 0, self.err
+}
+func TestConnectionBroken(t *testing.T) {
+	log.Println("TestConnectionBroken")
+
+	buffer := CreateBuffer(1, time.Second)
+	unittest.NotNil(t, buffer)
+
+	conn := &failingConnection{}
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			return conn, nil
+		},
+		Buffer: buffer,
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	fmt.Println("timeout")
+	conn.fail(&timeoutError{})
+	c, err := l.Dial()
+	unittest.IsNil(t, err)
+	_, err = c.Read(nil)
+	unittest.Equals(t, err, error(&timeoutError{}))
+	unittest.IsNil(t, c.Close())
+	// a timeout doesn't break our connection
+	unittest.Equals(t, l.ConnectionsAvailable(), 1)
+
+	fmt.Println("eof")
+	conn.fail(io.EOF)
+	c, err = l.Dial()
+	unittest.IsNil(t, err)
+	_, err = c.Write(nil)
+	unittest.Equals(t, err, io.EOF)
+	unittest.IsNil(t, c.Close())
+	unittest.Equals(t, l.Connections(), 0)
+	unittest.Equals(t, l.Stats().Disables, int64(1))
+	unittest.Equals(t, buffer.GetUsed(), 0)
+
+	fmt.Println("classifier")
+	protocol := fmt.Errorf("protocol error")
+	l.config.Broken = func(err error) bool {
+		return errors.Is(err, protocol)
+	}
+	conn.fail(io.EOF)
+	c, err = l.Dial()
+	unittest.IsNil(t, err)
+	_, err = c.Read(nil)
+	unittest.Equals(t, err, io.EOF)
+	conn.fail(protocol)
+	unittest.IsNil(t, c.Close())
+	unittest.Equals(t, l.ConnectionsAvailable(), 1)
+	c, err = l.Dial()
+	unittest.IsNil(t, err)
+	_, err = c.Read(nil)
+	unittest.Equals(t, err, protocol)
+	unittest.IsNil(t, c.Close())
+	unittest.Equals(t, l.Connections(), 0)
+	unittest.Equals(t, l.Stats().Disables, int64(2))
+	l.Close()
+}
+
+type countingConnection struct {
+	fakeConnection
+	reads  int
+	writes int
+	closes int
+}
+
+func (self *countingConnection) Read(b []byte) (n int, err error) {
+	self.reads++
+	return 0, nil
+}
+func (self *countingConnection) Write(b []byte) (n int, err error) {
+	self.writes++
+	return 0, nil
+}
+func (self *countingConnection) Close() error {
+	self.closes++
+	return nil
+}
+func TestConnectionReturned(t *testing.T) {
+	log.Println("TestConnectionReturned")
+
+	buffer := CreateBuffer(1, time.Second)
+	unittest.NotNil(t, buffer)
+
+	conn := &countingConnection{}
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			return conn, nil
+		},
+		Buffer: buffer,
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	fmt.Println("checkout")
+	c1, err := l.Dial()
+	unittest.IsNil(t, err)
+	_, err = c1.Write(nil)
+	unittest.IsNil(t, err)
+	unittest.IsNil(t, c1.Close())
+
+	// somebody else owns our connection now
+	c2, err := l.Dial()
+	unittest.IsNil(t, err)
+	unittest.Equals(t, c2 == c1, false)
+	unittest.Equals(t, c2.(*Connection).c, c1.(*Connection).c)
+
+	fmt.Println("use after return")
+	err = c1.Close()
+	unittest.Equals(t, errors.Is(err, ErrConnReturned), true)
+	_, err = c1.Read(nil)
+	unittest.Equals(t, errors.Is(err, ErrConnReturned), true)
+	_, err = c1.Write(nil)
+	unittest.Equals(t, errors.Is(err, ErrConnReturned), true)
+	unittest.Equals(t, errors.Is(c1.SetDeadline(time.Now()), ErrConnReturned), true)
+	c1.(*Connection).Disable()
+
+	// the connection was never touched
+	unittest.Equals(t, conn.reads, 0)
+	unittest.Equals(t, conn.writes, 1)
+	unittest.Equals(t, conn.closes, 0)
+	unittest.Equals(t, l.ConnectionsActive(), 1)
+	unittest.Equals(t, l.Stats().Disables, int64(0))
+	unittest.Equals(t, l.Stats().Invariants, int64(0))
+
+	fmt.Println("owner")
+	_, err = c2.Read(nil)
+	unittest.IsNil(t, err)
+	unittest.IsNil(t, c2.Close())
+	unittest.Equals(t, l.ConnectionsAvailable(), 1)
+	unittest.Equals(t, buffer.GetUsed(), 1)
+	l.Close()
+	unittest.Equals(t, conn.closes, 1)
+	unittest.Equals(t, buffer.GetUsed(), 0)
+}
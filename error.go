@@ -1,16 +1,142 @@
 package lagoon
 
-type dialError struct {
-	error
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+)
+
+var (
+	// the buffer had no free slot before our timeout
+	ErrBufferExhausted = ERR_TIMEDOUT
+	// the lagoon was closed
+	ErrPoolClosed = fmt.Errorf("Pool Closed")
+	// Config.Breaker is open, we didn't dial
+	ErrCircuitOpen = fmt.Errorf("Circuit Open")
+	// the connection was held for longer than Config.MaxCheckoutDuration and was taken back by the lagoon
+	ErrReclaimed = fmt.Errorf("Connection Reclaimed")
+	// the Connection was already closed and returned to the lagoon
+	ErrConnReturned = fmt.Errorf("Connection Returned")
+)
+
+// the lagoon couldn't provide a connection, or took it back
+// check Err with errors.Is for ErrBufferExhausted, ErrPoolClosed, ErrCircuitOpen, ErrReclaimed or ErrConnReturned
+type PoolError struct {
+	Err error
 }
 
-func (self *dialError) Timeout() bool {
+func (self *PoolError) Error() string {
+	return self.Err.Error()
+}
+func (self *PoolError) Unwrap() error {
+	return self.Err
+}
+func (self *PoolError) Timeout() bool {
 	// net.Error
 	// Is the error a timeout?
-	return true
+	return self.Err == ERR_TIMEDOUT
+}
+func (self *PoolError) Temporary() bool {
+	// net.Error
+	// Is the error temporary?
+	// a closed pool will never recover
+	return self.Err == ERR_TIMEDOUT || self.Err == ErrCircuitOpen
+}
+
+// Config.Dial, Config.DialContext or Config.OnDial failed
+type DialError struct {
+	Err error
+}
+
+func (self *DialError) Error() string {
+	return "Dial Failed: " + self.Err.Error()
+}
+func (self *DialError) Unwrap() error {
+	return self.Err
+}
+func (self *DialError) Timeout() bool {
+	// net.Error
+	// Is the error a timeout?
+	var e net.Error
+	return errors.As(self.Err, &e) && e.Timeout()
+}
+func (self *DialError) Temporary() bool {
+	// net.Error
+	// Is the error temporary?
+	var e interface {
+		Temporary() bool
+	}
+	return errors.As(self.Err, &e) && e.Temporary()
+}
+
+// Config.TestOnBorrow or Config.TestOnReturn failed
+type HealthCheckError struct {
+	Err error
+}
+
+func (self *HealthCheckError) Error() string {
+	return "Health Check Failed: " + self.Err.Error()
+}
+func (self *HealthCheckError) Unwrap() error {
+	return self.Err
+}
+func (self *HealthCheckError) Timeout() bool {
+	// net.Error
+	// Is the error a timeout?
+	var e net.Error
+	return errors.As(self.Err, &e) && e.Timeout()
 }
-func (self *dialError) Temporary() bool {
+func (self *HealthCheckError) Temporary() bool {
 	// net.Error
 	// Is the error temporary?
+	// the connection was discarded, a fresh connection may be healthy
 	return true
 }
+
+// our context was cancelled or its deadline was exceeded
+// check Err with errors.Is for context.Canceled or context.DeadlineExceeded
+type ContextError struct {
+	Err error
+}
+
+func (self *ContextError) Error() string {
+	return self.Err.Error()
+}
+func (self *ContextError) Unwrap() error {
+	return self.Err
+}
+func (self *ContextError) Timeout() bool {
+	// net.Error
+	// Is the error a timeout?
+	return self.Err == context.DeadlineExceeded
+}
+func (self *ContextError) Temporary() bool {
+	// net.Error
+	// Is the error temporary?
+	return self.Err == context.DeadlineExceeded
+}
+
+// the default Config.Broken
+// end of file, resets and any non-timeout net.Error leave a connection unusable
+func IsBroken(
+	err error,
+) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var e net.Error
+	if errors.As(err, &e) {
+		// a timeout can be retried on the same connection
+		return !e.Timeout()
+	}
+	return false
+}
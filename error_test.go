@@ -0,0 +1,100 @@
+package lagoon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sabey.co/unittest"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type timeoutError struct{}
+
+func (self *timeoutError) Error() string {
+	return "i/o timeout"
+}
+func (self *timeoutError) Timeout() bool {
+	return true
+}
+func (self *timeoutError) Temporary() bool {
+	return true
+}
+
+func TestErrors(t *testing.T) {
+	log.Println("TestErrors")
+
+	buffer := CreateBuffer(1, time.Millisecond*50)
+	unittest.NotNil(t, buffer)
+
+	refused := fmt.Errorf("connection refused")
+	var dial error
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			if dial != nil {
+				return nil, dial
+			}
+			return &fakeConnection{}, nil
+		},
+		Buffer: buffer,
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	// dial errors wrap our underlying error
+	fmt.Println("dial refused")
+	dial = refused
+	_, err = l.Dial()
+	unittest.Equals(t, errors.Is(err, refused), true)
+	var de *DialError
+	unittest.Equals(t, errors.As(err, &de), true)
+	var ne net.Error
+	unittest.Equals(t, errors.As(err, &ne), true)
+	unittest.Equals(t, ne.Timeout(), false)
+
+	fmt.Println("dial timeout")
+	dial = &timeoutError{}
+	_, err = l.Dial()
+	unittest.Equals(t, errors.As(err, &ne), true)
+	unittest.Equals(t, ne.Timeout(), true)
+	dial = nil
+
+	// buffer exhausted
+	fmt.Println("buffer exhausted")
+	c, err := l.Dial()
+	unittest.IsNil(t, err)
+	_, err = l.Dial()
+	unittest.Equals(t, errors.Is(err, ErrBufferExhausted), true)
+	var pe *PoolError
+	unittest.Equals(t, errors.As(err, &pe), true)
+	unittest.Equals(t, pe.Timeout(), true)
+
+	// context
+	fmt.Println("context cancelled")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = l.DialContext(ctx)
+	unittest.Equals(t, errors.Is(err, context.Canceled), true)
+	var ce *ContextError
+	unittest.Equals(t, errors.As(err, &ce), true)
+	unittest.Equals(t, ce.Timeout(), false)
+	unittest.IsNil(t, c.Close())
+}
+func TestIsBroken(t *testing.T) {
+	log.Println("TestIsBroken")
+
+	unittest.Equals(t, IsBroken(nil), false)
+	unittest.Equals(t, IsBroken(io.EOF), true)
+	unittest.Equals(t, IsBroken(fmt.Errorf("read: %w", io.ErrUnexpectedEOF)), true)
+	unittest.Equals(t, IsBroken(&net.OpError{Op: "read", Err: syscall.ECONNRESET}), true)
+	unittest.Equals(t, IsBroken(syscall.EPIPE), true)
+	unittest.Equals(t, IsBroken(&net.OpError{Op: "read", Err: &timeoutError{}}), false)
+	unittest.Equals(t, IsBroken(&timeoutError{}), false)
+	unittest.Equals(t, IsBroken(fmt.Errorf("protocol error")), false)
+}
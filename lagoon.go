@@ -1,26 +1,39 @@
 package lagoon
 
 import (
+	"container/list"
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
 	ERR_TIMEDOUT   = fmt.Errorf("Timed-out")
-	ERR_DIAL_EMPTY = fmt.Errorf("Dial Available Was Empty!!!")
+	ERR_DIAL_EMPTY = fmt.Errorf("Dial Returned An Empty Connection")
 )
 
 type Lagoon struct {
 	// safe
-	config *Config
+	config  *Config
+	stats   *lagoonStats
+	breaker *breaker
 	// unsafe
-	available      map[*Connection]struct{}
-	active         map[*Connection]struct{}
-	ticker_running time.Time
-	ticker_stop    bool
+	available      map[*pooledConn]*list.Element
+	idle           *list.List
+	active         map[*pooledConn]struct{}
+	requests       *list.List
+	hooks          []hook
+	idling         bool
+	closed         bool
+	drained        chan struct{}
+	refill         chan struct{}
+	expiry         expiryHeap
+	timer          *time.Timer
+	timer_deadline time.Time
 	mu             sync.RWMutex
 }
 
@@ -40,8 +53,18 @@ func CreateLagoon(
 	}
 	l := &Lagoon{
 		config:    config,
-		available: make(map[*Connection]struct{}),
-		active:    make(map[*Connection]struct{}),
+		stats:     &lagoonStats{},
+		available: make(map[*pooledConn]*list.Element),
+		idle:      list.New(),
+		active:    make(map[*pooledConn]struct{}),
+		requests:  list.New(),
+	}
+	l.breaker = createBreaker(config.Breaker, l.stats)
+	if config.MinIdle > 0 {
+		// keep MinIdle connections available in the background
+		l.refill = make(chan struct{}, 1)
+		l.refill <- struct{}{}
+		go l.refiller()
 	}
 	if config.DialInitial > 0 {
 		// if there's an initial amount of connections we will attempt to create them
@@ -65,64 +88,360 @@ func (self *Lagoon) IsValid() bool {
 	}
 	return true
 }
-func (self *Lagoon) dial() error {
+func (self *Lagoon) dial(
+	ctx context.Context,
+) (
+	*pooledConn,
+	error,
+) {
+	// assumed that we're NOT locked
+	// acquiring and dialing can block, other callers must not wait behind us
+	if self.breaker.rejecting() {
+		// our backend is failing, don't wait on a buffer slot that we can't dial with
+		return nil, &PoolError{ErrCircuitOpen}
+	}
 	// acquire
-	if !self.config.Buffer.acquire() {
+	if err := self.config.Buffer.acquire(ctx, self); err != nil {
 		// failed to acquire
-		return &dialError{ERR_TIMEDOUT}
+		if errors.Is(err, ERR_TIMEDOUT) {
+			atomic.AddInt64(&self.stats.timeouts, 1)
+		}
+		return nil, err
 	}
-	conn, err := self.config.Dial()
+	return self.dialAcquired(ctx)
+}
+func (self *Lagoon) dialAcquired(
+	ctx context.Context,
+) (
+	*pooledConn,
+	error,
+) {
+	// assumed that we're NOT locked
+	// assumed that the buffer was acquired
+	// retries keep our buffer slot, we never rejoin the queue between attempts
+	for attempt := 1; ; attempt++ {
+		if !self.breakerAllow() {
+			// our backend is failing, don't wait on it
+			self.config.Buffer.release()
+			return nil, &PoolError{ErrCircuitOpen}
+		}
+		c, abandoned, err := self.dialOnce(ctx)
+		self.breakerDone(err)
+		if err == nil {
+			// BUFFER MUST BE RELEASED
+			return c, nil
+		}
+		self.onDialError(err)
+		if abandoned {
+			// our slot is held until the abandoned Dial returns
+			return nil, err
+		}
+		if !self.retry(ctx, attempt, err) {
+			// failed to dial - release
+			self.config.Buffer.release()
+			return nil, err
+		}
+	}
+}
+func (self *Lagoon) dialOnce(
+	ctx context.Context,
+) (
+	*pooledConn,
+	bool,
+	error,
+) {
+	// assumed that we're NOT locked
+	// returns true if our buffer slot was handed to an abandoned Dial
+	atomic.AddInt64(&self.stats.dials, 1)
+	started := time.Now()
+	conn, abandoned, err := self.dialConn(ctx)
+	self.stats.dial_histogram.observe(time.Since(started))
 	if err != nil {
-		// failed to dial - release
-		self.config.Buffer.release()
-		return err
+		atomic.AddInt64(&self.stats.dials_failed, 1)
+		return nil, abandoned, err
+	}
+	if conn == nil {
+		// dial broke its contract, we can't hand out nothing
+		atomic.AddInt64(&self.stats.invariants, 1)
+		atomic.AddInt64(&self.stats.dials_failed, 1)
+		return nil, false, &DialError{ERR_DIAL_EMPTY}
+	}
+	c := self.createConnection(conn)
+	// OnDial may talk through our Connection, it's only lent to OnDial until it returns
+	c.lent.Store(c.handle)
+	err = self.onDial(c.handle)
+	c.lent.Store((*Connection)(nil))
+	if err != nil {
+		// failed to setup - close
+		atomic.AddInt64(&self.stats.dials_failed, 1)
+		conn.Close()
+		return nil, false, &DialError{err}
 	}
 	// dialed
-	// wrap connection and store in available
-	self.available[self.createConnection(conn)] = struct{}{}
-	// toggle tick
-	self.toggleTick()
-	return nil
+	atomic.AddInt64(&self.stats.dials_succeeded, 1)
+	return c, false, nil
+}
+func (self *Lagoon) dialConn(
+	ctx context.Context,
+) (
+	net.Conn,
+	bool,
+	error,
+) {
+	// returns true if our buffer slot was handed to an abandoned Dial
+	if self.config.DialContext != nil {
+		conn, err := self.config.DialContext(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				// dial was aborted by our context
+				return nil, false, &ContextError{ctx.Err()}
+			}
+			return nil, false, &DialError{err}
+		}
+		return conn, false, nil
+	}
+	if ctx.Done() == nil {
+		// we can never be cancelled, there's nothing to race
+		conn, err := self.config.Dial()
+		if err != nil {
+			return nil, false, &DialError{err}
+		}
+		return conn, false, nil
+	}
+	// Dial can't be cancelled, race it against our context
+	type dialed struct {
+		conn net.Conn
+		err  error
+	}
+	result := make(chan dialed, 1)
+	go func() {
+		conn, err := self.config.Dial()
+		result <- dialed{conn, err}
+	}()
+	select {
+	case r := <-result:
+		if r.err != nil {
+			return nil, false, &DialError{r.err}
+		}
+		return r.conn, false, nil
+	case <-ctx.Done():
+		// nobody is waiting for this connection anymore
+		// our buffer slot is held until Dial returns, a hanging backend must not grow past our buffer
+		go func() {
+			if r := <-result; r.err == nil && r.conn != nil {
+				r.conn.Close()
+			}
+			self.config.Buffer.release()
+		}()
+		return nil, true, &ContextError{ctx.Err()}
+	}
 }
 func (self *Lagoon) DialInitialize() error {
 	// dial initialize will allow us to allocate a new connection
 	// if successful, connection will be moved to the available connections
-	self.mu.Lock()
-	err := self.dial()
-	self.mu.Unlock()
+	if self.IsClosed() {
+		return &PoolError{ErrPoolClosed}
+	}
+	c, err := self.dial(context.Background())
 	if err != nil {
 		return err
 	}
+	// store in available, or hand to a waiting caller
+	self.mu.Lock()
+	if self.closed {
+		// we were closed while dialing
+		self.mu.Unlock()
+		c.discard(true)
+		return &PoolError{ErrPoolClosed}
+	}
+	self.put(c)
+	self.mu.Unlock()
 	return nil
 }
 func (self *Lagoon) Dial() (
 	net.Conn,
 	error,
 ) {
+	return self.DialContext(context.Background())
+}
+func (self *Lagoon) DialContext(
+	ctx context.Context,
+) (
+	net.Conn,
+	error,
+) {
+	c, err := self.dialContext(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+func (self *Lagoon) dialContext(
+	ctx context.Context,
+	fresh bool,
+) (
+	*Connection,
+	error,
+) {
+	// if fresh, we always dial a new connection instead of checking out an available one
+	// every checkout is waited on, even when a connection was available right away
+	started := time.Now()
+	defer func() {
+		self.stats.waited(time.Since(started))
+	}()
+	for {
+		if err := ctx.Err(); err != nil {
+			// context is already done
+			return nil, &ContextError{err}
+		}
+		c, idle, err := self.checkout(ctx, fresh)
+		if err != nil {
+			return nil, err
+		}
+		if self.borrow(c, idle) {
+			self.checkedOut(c.c)
+			self.onCheckout(c)
+			return c, nil
+		}
+		// failed health check and was discarded
+		// try again, we will eventually dial a fresh connection
+	}
+}
+func (self *Lagoon) checkout(
+	ctx context.Context,
+	fresh bool,
+) (
+	*Connection,
+	time.Time,
+	error,
+) {
+	// returns an active connection and when it became idle
+	// freshly dialed connections were never idle
 	// get connection
 	self.mu.Lock()
-	defer self.mu.Unlock()
-	if len(self.available) == 0 {
-		// dial new connection
-		if err := self.dial(); err != nil {
-			// failed to dial
-			return nil, err
+	if self.closed {
+		self.mu.Unlock()
+		return nil, time.Time{}, &PoolError{ErrPoolClosed}
+	}
+	if !fresh {
+		if c := self.selectAvailable(); c != nil {
+			// remove from available
+			self.removeAvailable(c)
+			// store in active
+			idle := c.idle
+			h := self.activate(c)
+			// toggle tick
+			self.toggleTick()
+			self.mu.Unlock()
+			return h, idle, nil
+		}
+	}
+	// nothing available, or we must dial
+	if self.breaker.rejecting() {
+		// our backend is failing, don't wait on a buffer slot that we can't dial with
+		self.mu.Unlock()
+		return nil, time.Time{}, &PoolError{ErrCircuitOpen}
+	}
+	if fresh {
+		// returned connections aren't fresh, we only wait on a buffer slot
+		// our own idle connections may be stale too, they're stolen like everybody else's
+		self.mu.Unlock()
+		if err := self.config.Buffer.acquire(ctx, nil); err != nil {
+			// failed to acquire
+			if errors.Is(err, ERR_TIMEDOUT) {
+				atomic.AddInt64(&self.stats.timeouts, 1)
+			}
+			return nil, time.Time{}, err
+		}
+		return self.checkoutAcquired(ctx)
+	}
+	// wait for a connection to be returned or for a buffer slot, whichever comes first
+	req := self.request()
+	self.mu.Unlock()
+	h, err := self.config.Buffer.await(ctx, self, req.conn)
+	if h != nil {
+		// a returned connection was handed to us, it was returned just now
+		return h, time.Now(), nil
+	}
+	if h = self.cancelRequest(req); h != nil {
+		// a connection was also handed to us as we stopped waiting
+		if err == nil {
+			// we don't need our slot, pass it on
+			self.config.Buffer.release()
+		} else if !errors.Is(err, ERR_TIMEDOUT) {
+			// caller gave up, return the connection that was handed to us
+			h.Close()
+			return nil, time.Time{}, err
+		}
+		return h, time.Now(), nil
+	}
+	if err != nil {
+		// failed to acquire
+		if errors.Is(err, ERR_TIMEDOUT) {
+			atomic.AddInt64(&self.stats.timeouts, 1)
 		}
+		return nil, time.Time{}, err
+	}
+	return self.checkoutAcquired(ctx)
+}
+func (self *Lagoon) checkoutAcquired(
+	ctx context.Context,
+) (
+	*Connection,
+	time.Time,
+	error,
+) {
+	// assumed that we're NOT locked
+	// assumed that the buffer was acquired
+	// dial new connection without holding our lock
+	c, err := self.dialAcquired(ctx)
+	if err != nil {
+		// failed to dial
+		return nil, time.Time{}, err
+	}
+	// store in active
+	self.mu.Lock()
+	if self.closed {
+		// we were closed while dialing
+		self.mu.Unlock()
+		c.discard(true)
+		return nil, time.Time{}, &PoolError{ErrPoolClosed}
+	}
+	h := self.activate(c)
+	self.mu.Unlock()
+	return h, time.Time{}, nil
+}
+func (self *Lagoon) activate(
+	c *pooledConn,
+) *Connection {
+	// assumed that we're locked
+	// store in active
+	c.idle = time.Time{}
+	c.uses++
+	self.active[c] = struct{}{}
+	return c.lend()
+}
+func (self *Lagoon) borrow(
+	c *Connection,
+	idle time.Time,
+) bool {
+	// assumed that we're NOT locked
+	// returns false if the connection failed its health check and was discarded
+	if self.config.TestOnBorrow == nil || idle.IsZero() {
+		// nothing to test or freshly dialed
+		return true
 	}
-	// acquired something
-	for conn, _ := range self.available {
-		// take the first result
-		// remove from available
-		delete(self.available, conn)
-		// store in active
-		conn.idle = time.Time{}
-		self.active[conn] = struct{}{}
-		// toggle tick
-		self.toggleTick()
-		return conn, nil
+	if self.config.TestIdleAfter > 0 && time.Since(idle) < self.config.TestIdleAfter {
+		// recently used, assume it's healthy
+		return true
+	}
+	if err := self.config.TestOnBorrow(c); err != nil {
+		// unhealthy - remove from lagoon and release buffer
+		c.Disable()
+		c.Close()
+		return false
 	}
-	log.Panicln("./lagoon.Dial(): available container was empty, wtf???")
-	return nil, ERR_DIAL_EMPTY
+	return true
 }
 func (self *Lagoon) Connections() int {
 	self.mu.RLock()
@@ -139,43 +458,133 @@ func (self *Lagoon) ConnectionsActive() int {
 	defer self.mu.RUnlock()
 	return len(self.active)
 }
+func (self *Lagoon) IsClosed() bool {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	return self.closed
+}
 func (self *Lagoon) Close() {
-	// pool will remain usable even once closed!
-	// we will only CLOSE and REMOVE all connections!
+	// pool will no longer be usable once closed!
+	// we will CLOSE and REMOVE all connections, Dial will fail with ErrPoolClosed
+	// connections dialed or returned after we're closed will be closed
 	self.mu.Lock()
-	self.closeAvailable()
-	self.closeActive()
-	self.mu.Unlock()
+	self.closed = true
+	// wake every waiting caller
+	self.closeRequests()
+	closing := self.closeAvailable()
+	closing = append(closing, self.closeActive()...)
+	self.unlock()
+	// close outside of our lock
+	discardConnections(closing)
+}
+func (self *Lagoon) Drain(
+	ctx context.Context,
+) error {
+	// pool will no longer be usable once drained!
+	// available connections are closed immediately
+	// active connections are closed as they're returned, or forcibly once our context is done
+	self.mu.Lock()
+	self.closed = true
+	// wake every waiting caller
+	self.closeRequests()
+	closing := self.closeAvailable()
+	if self.drained == nil {
+		self.drained = make(chan struct{})
+		self.drain()
+	}
+	drained := self.drained
+	self.unlock()
+	// close outside of our lock
+	discardConnections(closing)
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		// we can't wait any longer
+		self.mu.Lock()
+		closing = self.closeActive()
+		self.unlock()
+		discardConnections(closing)
+		return &ContextError{ctx.Err()}
+	}
+}
+func (self *Lagoon) drain() {
+	// assumed that we're locked
+	// let Drain know once every active connection is gone
+	if self.drained == nil || len(self.active) > 0 {
+		return
+	}
+	select {
+	case <-self.drained:
+		// already drained
+	default:
+		close(self.drained)
+	}
 }
 func (self *Lagoon) CloseAvailable() {
 	// pool will remain usable even once closed!
 	// we will only CLOSE and REMOVE all available connections!
 	self.mu.Lock()
-	self.closeAvailable()
-	self.mu.Unlock()
+	closing := self.closeAvailable()
+	self.unlock()
+	// close outside of our lock
+	discardConnections(closing)
 }
-func (self *Lagoon) closeAvailable() {
-	// close available
+func (self *Lagoon) closeAvailable() []*pooledConn {
+	// assumed that we're locked
+	// connections must be discarded once we're unlocked
+	closing := make([]*pooledConn, 0, len(self.available))
 	for c, _ := range self.available {
-		c.Disable()
-		c.close()
+		if c.disable() {
+			self.later(self.config.OnDisable, c)
+		}
+		closing = append(closing, c)
 	}
 	// clean containers
-	self.available = make(map[*Connection]struct{})
+	self.available = make(map[*pooledConn]*list.Element)
+	self.idle = list.New()
+	for _, c := range self.expiry {
+		c.index = -1
+	}
+	self.expiry = nil
+	// toggle tick
+	self.toggleTick()
+	return closing
 }
 func (self *Lagoon) CloseActive() {
 	// pool will remain usable even once closed!
 	// we will only CLOSE and REMOVE all active connections!
 	self.mu.Lock()
-	self.closeActive()
-	self.mu.Unlock()
+	closing := self.closeActive()
+	self.unlock()
+	// close outside of our lock
+	discardConnections(closing)
 }
-func (self *Lagoon) closeActive() {
-	// close active
+func (self *Lagoon) closeActive() []*pooledConn {
+	// assumed that we're locked
+	// connections must be discarded once we're unlocked
+	closing := make([]*pooledConn, 0, len(self.active))
 	for c, _ := range self.active {
-		c.Disable()
-		c.close()
+		if c.disable() {
+			self.later(self.config.OnDisable, c)
+		}
+		c.mu.Lock()
+		c.returned()
+		// our owner's Close must not close us again
+		c.yanked = true
+		c.mu.Unlock()
+		closing = append(closing, c)
 	}
 	// clean containers
-	self.active = make(map[*Connection]struct{})
+	self.active = make(map[*pooledConn]struct{})
+	self.drain()
+	return closing
+}
+func discardConnections(
+	closing []*pooledConn,
+) {
+	for _, c := range closing {
+		// every connection we've removed was holding the buffer
+		c.discard(true)
+	}
 }
@@ -0,0 +1,211 @@
+package lagoon
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sabey.co/unittest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBreakerValidate(t *testing.T) {
+	log.Println("TestBreakerValidate")
+
+	var breaker *Breaker
+	unittest.IsNil(t, breaker.Validate())
+	unittest.IsNil(t, breaker.Clone())
+
+	breaker = &Breaker{}
+	unittest.Equals(t, breaker.Validate(), ERR_BREAKER_THRESHOLD)
+	breaker.Threshold = 1.5
+	unittest.Equals(t, breaker.Validate(), ERR_BREAKER_THRESHOLD)
+	breaker.Threshold = 0.5
+	unittest.Equals(t, breaker.Validate(), ERR_BREAKER_WINDOW)
+	breaker.Window = 4
+	unittest.Equals(t, breaker.Validate(), ERR_BREAKER_COOLDOWN)
+	breaker.Cooldown = time.Second
+	unittest.IsNil(t, breaker.Validate())
+	unittest.Equals(t, BREAKER_HALF_OPEN.String(), "half-open")
+}
+func TestLagoonBreaker(t *testing.T) {
+	log.Println("TestLagoonBreaker")
+
+	buffer := CreateBuffer(5, time.Second)
+	unittest.NotNil(t, buffer)
+
+	var mu sync.Mutex
+	down := true
+	var probe chan struct{}
+	var states []BreakerState
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			mu.Lock()
+			d, p := down, probe
+			mu.Unlock()
+			if p != nil {
+				<-p
+			}
+			if d {
+				return nil, fmt.Errorf("refused")
+			}
+			return &fakeConnection{}, nil
+		},
+		Buffer: buffer,
+		Breaker: &Breaker{
+			Threshold: 0.5,
+			Window:    4,
+			Cooldown:  time.Millisecond * 50,
+		},
+		OnBreaker: func(state BreakerState) {
+			mu.Lock()
+			states = append(states, state)
+			mu.Unlock()
+		},
+	}
+	set := func(
+		d bool,
+		p chan struct{},
+	) {
+		mu.Lock()
+		down = d
+		probe = p
+		mu.Unlock()
+	}
+	changed := func() []BreakerState {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]BreakerState(nil), states...)
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	fmt.Println("open")
+	set(false, nil)
+	c, err := l.Dial()
+	unittest.IsNil(t, err)
+	c.(*Connection).Disable()
+	c.Close()
+	set(true, nil)
+	for i := 0; i < 3; i++ {
+		_, err = l.Dial()
+		var e *DialError
+		unittest.Equals(t, errors.As(err, &e), true)
+	}
+	// 2 of our last 4 dials failed
+	unittest.Equals(t, l.Stats().Breaker, BREAKER_OPEN)
+	unittest.Equals(t, l.Stats().BreakerOpens, int64(1))
+	unittest.Equals(t, changed(), []BreakerState{BREAKER_OPEN})
+
+	fmt.Println("fail fast")
+	started := time.Now()
+	_, err = l.Dial()
+	unittest.Equals(t, errors.Is(err, ErrCircuitOpen), true)
+	e, ok := err.(*PoolError)
+	unittest.Equals(t, ok, true)
+	unittest.Equals(t, e.Timeout(), false)
+	unittest.Equals(t, e.Temporary(), true)
+	unittest.Equals(t, time.Since(started) < time.Millisecond*50, true)
+	unittest.Equals(t, l.Stats().Dials, int64(4))
+	unittest.Equals(t, l.Stats().BreakerRejections, int64(1))
+	unittest.Equals(t, buffer.GetUsed(), 0)
+
+	fmt.Println("failed probe")
+	<-time.After(time.Millisecond * 60)
+	_, err = l.Dial()
+	var de *DialError
+	unittest.Equals(t, errors.As(err, &de), true)
+	unittest.Equals(t, l.Stats().Breaker, BREAKER_OPEN)
+	unittest.Equals(t, l.Stats().BreakerOpens, int64(2))
+	unittest.Equals(t, changed(), []BreakerState{BREAKER_OPEN, BREAKER_HALF_OPEN, BREAKER_OPEN})
+
+	fmt.Println("single probe")
+	<-time.After(time.Millisecond * 60)
+	p := make(chan struct{})
+	set(false, p)
+	probed := make(chan error, 1)
+	go func() {
+		c, err := l.Dial()
+		if err == nil {
+			c.Close()
+		}
+		probed <- err
+	}()
+	for l.Stats().Breaker != BREAKER_HALF_OPEN {
+		<-time.After(time.Millisecond)
+	}
+	// our probe is still dialing
+	_, err = l.Dial()
+	unittest.Equals(t, errors.Is(err, ErrCircuitOpen), true)
+	close(p)
+	unittest.IsNil(t, <-probed)
+	unittest.Equals(t, l.Stats().Breaker, BREAKER_CLOSED)
+	unittest.Equals(t, changed(), []BreakerState{BREAKER_OPEN, BREAKER_HALF_OPEN, BREAKER_OPEN, BREAKER_HALF_OPEN, BREAKER_CLOSED})
+
+	fmt.Println("closed")
+	set(false, nil)
+	c, err = l.Dial()
+	unittest.IsNil(t, err)
+	unittest.IsNil(t, c.Close())
+	unittest.Equals(t, l.Stats().BreakerRejections, int64(2))
+	l.Close()
+}
+func TestLagoonBreakerBufferFull(t *testing.T) {
+	log.Println("TestLagoonBreakerBufferFull")
+
+	buffer := CreateBuffer(1, time.Second)
+	unittest.NotNil(t, buffer)
+
+	l, err := CreateLagoon(&Config{
+		Dial: func() (net.Conn, error) {
+			return nil, fmt.Errorf("refused")
+		},
+		Buffer: buffer,
+		Breaker: &Breaker{
+			Threshold: 0.5,
+			Window:    2,
+			Cooldown:  time.Second,
+		},
+	})
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	fmt.Println("open")
+	for i := 0; i < 2; i++ {
+		_, err = l.Dial()
+		var e *DialError
+		unittest.Equals(t, errors.As(err, &e), true)
+	}
+	unittest.Equals(t, l.Stats().Breaker, BREAKER_OPEN)
+
+	// another lagoon holds our only slot
+	o, err := CreateLagoon(&Config{
+		Dial: func() (net.Conn, error) {
+			return &fakeConnection{}, nil
+		},
+		Buffer: buffer,
+	})
+	unittest.IsNil(t, err)
+	c, err := o.Dial()
+	unittest.IsNil(t, err)
+
+	// we fail fast instead of waiting on a full buffer
+	fmt.Println("fail fast")
+	started := time.Now()
+	_, err = l.Dial()
+	unittest.Equals(t, errors.Is(err, ErrCircuitOpen), true)
+	unittest.Equals(t, errors.Is(l.DialInitialize(), ErrCircuitOpen), true)
+	unittest.Equals(t, time.Since(started) < time.Millisecond*50, true)
+	unittest.Equals(t, l.Stats().Timeouts, int64(0))
+	unittest.Equals(t, l.Stats().BreakerRejections, int64(2))
+	unittest.Equals(t, buffer.GetWaiting(), 0)
+
+	unittest.IsNil(t, c.Close())
+	o.Close()
+	l.Close()
+	unittest.Equals(t, buffer.GetUsed(), 0)
+}
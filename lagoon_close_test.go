@@ -0,0 +1,155 @@
+package lagoon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sabey.co/unittest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLagoonClose(t *testing.T) {
+	log.Println("TestLagoonClose")
+
+	buffer := CreateBuffer(2, time.Second*5)
+	unittest.NotNil(t, buffer)
+
+	dialing := make(chan struct{})
+	dialed := make(chan struct{})
+	block := false
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			if block {
+				close(dialing)
+				<-dialed
+			}
+			return &fakeConnection{}, nil
+		},
+		Buffer:      buffer,
+		IdleTimeout: time.Minute,
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	c, err := l.Dial()
+	unittest.IsNil(t, err)
+
+	// one caller is dialing, another is waiting on our full pool
+	fmt.Println("close while dialing and waiting")
+	block = true
+	errs := make(chan error, 2)
+	go func() {
+		_, err := l.Dial()
+		errs <- err
+	}()
+	<-dialing
+	go func() {
+		_, err := l.Dial()
+		errs <- err
+	}()
+	for l.Stats().Waiting != 1 {
+		<-time.After(time.Millisecond)
+	}
+	l.Close()
+	unittest.Equals(t, errors.Is(<-errs, ErrPoolClosed), true)
+	close(dialed)
+	unittest.Equals(t, errors.Is(<-errs, ErrPoolClosed), true)
+
+	// nothing leaked against our buffer
+	unittest.Equals(t, l.Connections(), 0)
+	unittest.Equals(t, buffer.GetUsed(), 0)
+	unittest.Equals(t, buffer.GetWaiting(), 0)
+	unittest.Equals(t, l.Stats().Closed, true)
+
+	// closed connections stay closed
+	fmt.Println("close connection")
+	c.Close()
+	unittest.Equals(t, l.Connections(), 0)
+	unittest.Equals(t, l.Stats().Invariants, int64(0))
+
+	// expiry timer is stopped for good
+	l.mu.RLock()
+	unittest.Equals(t, l.timer_deadline.IsZero(), true)
+	l.mu.RUnlock()
+}
+func TestLagoonCloseLate(t *testing.T) {
+	log.Println("TestLagoonCloseLate")
+
+	buffer := CreateBuffer(5, time.Second)
+	unittest.NotNil(t, buffer)
+
+	conn := &countingConnection{}
+	var closed int64
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			return conn, nil
+		},
+		Buffer: buffer,
+		OnClose: func(c *Connection, err error) {
+			atomic.AddInt64(&closed, 1)
+		},
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	late := func(c net.Conn) {
+		// once closed, our Connection never reaches the closed connection again
+		writes := conn.writes
+		err := c.Close()
+		unittest.Equals(t, errors.Is(err, ErrConnReturned), true)
+		_, err = c.Write(nil)
+		unittest.Equals(t, errors.Is(err, ErrConnReturned), true)
+		unittest.Equals(t, errors.Is(c.SetDeadline(time.Time{}), ErrConnReturned), true)
+		unittest.Equals(t, conn.writes, writes)
+	}
+
+	// the lagoon closed our connection, closing it late must not close it again
+	fmt.Println("close active")
+	c, err := l.Dial()
+	unittest.IsNil(t, err)
+	l.CloseActive()
+	unittest.Equals(t, conn.closes, 1)
+	unittest.IsNil(t, c.Close())
+	unittest.Equals(t, conn.closes, 1)
+	late(c)
+	unittest.Equals(t, atomic.LoadInt64(&closed), int64(1))
+	unittest.Equals(t, l.Stats().Closes, int64(1))
+	unittest.Equals(t, buffer.GetUsed(), 0)
+
+	fmt.Println("drain timeout")
+	c, err = l.Dial()
+	unittest.IsNil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	unittest.NotNil(t, l.Drain(ctx))
+	unittest.Equals(t, conn.closes, 2)
+	unittest.IsNil(t, c.Close())
+	unittest.Equals(t, conn.closes, 2)
+	late(c)
+	unittest.Equals(t, atomic.LoadInt64(&closed), int64(2))
+	unittest.Equals(t, l.Stats().Closes, int64(2))
+	unittest.Equals(t, l.Stats().Invariants, int64(0))
+	unittest.Equals(t, buffer.GetUsed(), 0)
+
+	fmt.Println("close")
+	l, err = CreateLagoon(config)
+	unittest.IsNil(t, err)
+	c, err = l.Dial()
+	unittest.IsNil(t, err)
+	l.Close()
+	unittest.Equals(t, conn.closes, 3)
+	unittest.IsNil(t, c.Close())
+	unittest.Equals(t, conn.closes, 3)
+	late(c)
+	unittest.Equals(t, atomic.LoadInt64(&closed), int64(3))
+	unittest.Equals(t, l.Stats().Closes, int64(1))
+	unittest.Equals(t, buffer.GetUsed(), 0)
+}
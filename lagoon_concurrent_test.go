@@ -0,0 +1,62 @@
+package lagoon
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sabey.co/unittest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLagoonConcurrentDial(t *testing.T) {
+	log.Println("TestLagoonConcurrentDial")
+
+	buffer := CreateBuffer(5, time.Second*2)
+	unittest.NotNil(t, buffer)
+
+	var dialing int32
+	release := make(chan struct{})
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			atomic.AddInt32(&dialing, 1)
+			<-release
+			return &fakeConnection{}, nil
+		},
+		Buffer: buffer,
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	// slow dials must not block each other
+	fmt.Println("concurrent dials")
+	var wg sync.WaitGroup
+	wg.Add(3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			defer wg.Done()
+			c, err := l.Dial()
+			unittest.IsNil(t, err)
+			unittest.IsNil(t, c.Close())
+		}()
+	}
+	for atomic.LoadInt32(&dialing) < 3 {
+		<-time.After(time.Millisecond)
+	}
+
+	// pool must stay usable while dials are in-flight
+	fmt.Println("inspect while dialing")
+	unittest.Equals(t, l.Connections(), 0)
+	l.CloseAvailable()
+
+	close(release)
+	wg.Wait()
+	unittest.Equals(t, l.ConnectionsAvailable(), 3)
+	unittest.Equals(t, l.ConnectionsActive(), 0)
+	l.Close()
+	unittest.Equals(t, l.Connections(), 0)
+}
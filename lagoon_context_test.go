@@ -0,0 +1,110 @@
+package lagoon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sabey.co/unittest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLagoonDialContext(t *testing.T) {
+	log.Println("TestLagoonDialContext")
+
+	buffer := CreateBuffer(1, time.Second*5)
+	unittest.NotNil(t, buffer)
+
+	config := &Config{
+		DialContext: func(ctx context.Context) (net.Conn, error) {
+			return &fakeConnection{}, nil
+		},
+		Buffer: buffer,
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	c, err := l.DialContext(context.Background())
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, c)
+
+	// buffer is full, cancel while waiting
+	fmt.Println("cancel buffer wait")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	started := time.Now()
+	_, err = l.DialContext(ctx)
+	unittest.NotNil(t, err)
+	unittest.Equals(t, time.Since(started) < time.Second, true)
+	e, ok := err.(*ContextError)
+	unittest.Equals(t, ok, true)
+	unittest.Equals(t, e.Err, context.DeadlineExceeded)
+	unittest.Equals(t, e.Timeout(), true)
+	unittest.Equals(t, l.ConnectionsActive(), 1)
+	unittest.IsNil(t, c.Close())
+	l.Close()
+}
+func TestLagoonDialContextAbort(t *testing.T) {
+	log.Println("TestLagoonDialContextAbort")
+
+	buffer := CreateBuffer(1, time.Second*5)
+	unittest.NotNil(t, buffer)
+
+	// Dial can't be cancelled
+	dialing := make(chan struct{})
+	var inflight int64
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			atomic.AddInt64(&inflight, 1)
+			defer atomic.AddInt64(&inflight, -1)
+			<-dialing
+			return &fakeConnection{}, nil
+		},
+		Buffer: buffer,
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	fmt.Println("cancel in-flight dial")
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-time.After(time.Millisecond * 50)
+		cancel()
+	}()
+	_, err = l.DialContext(ctx)
+	unittest.NotNil(t, err)
+	e, ok := err.(*ContextError)
+	unittest.Equals(t, ok, true)
+	unittest.Equals(t, e.Err, context.Canceled)
+	unittest.Equals(t, e.Timeout(), false)
+	unittest.Equals(t, l.Connections(), 0)
+
+	// the abandoned dial still holds our buffer slot
+	fmt.Println("abandoned dial holds the buffer")
+	unittest.Equals(t, buffer.GetUsed(), 1)
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*5)
+		_, err = l.DialContext(ctx)
+		cancel()
+		unittest.Equals(t, errors.Is(err, context.DeadlineExceeded), true)
+	}
+	unittest.Equals(t, atomic.LoadInt64(&inflight), int64(1))
+	unittest.Equals(t, buffer.GetUsed(), 1)
+	close(dialing)
+	for buffer.GetUsed() != 0 {
+		<-time.After(time.Millisecond)
+	}
+
+	// buffer was released
+	c, err := l.Dial()
+	unittest.IsNil(t, err)
+	unittest.IsNil(t, c.Close())
+	l.Close()
+}
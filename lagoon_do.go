@@ -0,0 +1,61 @@
+package lagoon
+
+import (
+	"context"
+	"net"
+)
+
+func (self *Lagoon) Do(
+	ctx context.Context,
+	fn func(net.Conn) error,
+) error {
+	// check out a connection for the duration of fn
+	// it's returned if fn succeeds and disabled if fn fails or panics
+	return self.DoRetry(ctx, 0, fn)
+}
+func (self *Lagoon) DoRetry(
+	ctx context.Context,
+	retries int,
+	fn func(net.Conn) error,
+) error {
+	// like Do, but fn is retried up to retries times on a freshly dialed connection
+	// our available connections may all be as broken as the one that failed us
+	// fn must be idempotent, failing to check out a connection is never retried
+	for attempt := 0; ; attempt++ {
+		checkedOut, err := self.do(ctx, attempt > 0, fn)
+		if err == nil || !checkedOut || attempt >= retries || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+func (self *Lagoon) do(
+	ctx context.Context,
+	fresh bool,
+	fn func(net.Conn) error,
+) (
+	bool,
+	error,
+) {
+	// returns false if we failed to check out a connection
+	conn, err := self.dialContext(ctx, fresh)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			// we can't trust a connection that was in use when fn panicked
+			conn.Disable()
+			conn.Close()
+			panic(r)
+		}
+	}()
+	if err := fn(conn); err != nil {
+		// we don't know what state fn left our connection in
+		conn.Disable()
+		conn.Close()
+		return true, err
+	}
+	// fn succeeded, a failure to return our connection is the lagoon's problem
+	conn.Close()
+	return true, nil
+}
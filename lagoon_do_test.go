@@ -0,0 +1,149 @@
+package lagoon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sabey.co/unittest"
+	"testing"
+	"time"
+)
+
+func TestLagoonDo(t *testing.T) {
+	log.Println("TestLagoonDo")
+
+	buffer := CreateBuffer(2, time.Second)
+	unittest.NotNil(t, buffer)
+
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			return &fakeConnection{}, nil
+		},
+		Buffer: buffer,
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+	ctx := context.Background()
+
+	fmt.Println("success")
+	var used *pooledConn
+	err = l.Do(ctx, func(c net.Conn) error {
+		used = c.(*Connection).c
+		return nil
+	})
+	unittest.IsNil(t, err)
+	unittest.Equals(t, l.ConnectionsAvailable(), 1)
+	unittest.Equals(t, l.ConnectionsActive(), 0)
+
+	fmt.Println("error")
+	failed := fmt.Errorf("failed")
+	err = l.Do(ctx, func(c net.Conn) error {
+		unittest.Equals(t, c.(*Connection).c, used)
+		return failed
+	})
+	unittest.Equals(t, err, failed)
+	unittest.Equals(t, l.Connections(), 0)
+	unittest.Equals(t, l.Stats().Disables, int64(1))
+	unittest.Equals(t, buffer.GetUsed(), 0)
+
+	fmt.Println("panic")
+	func() {
+		defer func() {
+			unittest.Equals(t, recover(), "panicked")
+		}()
+		l.Do(ctx, func(c net.Conn) error {
+			panic("panicked")
+		})
+	}()
+	unittest.Equals(t, l.Connections(), 0)
+	unittest.Equals(t, l.Stats().Disables, int64(2))
+	unittest.Equals(t, buffer.GetUsed(), 0)
+
+	fmt.Println("retry")
+	var conns []*pooledConn
+	err = l.DoRetry(ctx, 2, func(c net.Conn) error {
+		conns = append(conns, c.(*Connection).c)
+		if len(conns) < 3 {
+			return failed
+		}
+		return nil
+	})
+	unittest.IsNil(t, err)
+	unittest.Equals(t, len(conns), 3)
+	// every attempt gets another connection
+	unittest.Equals(t, conns[0] == conns[1] || conns[1] == conns[2], false)
+	unittest.Equals(t, l.ConnectionsAvailable(), 1)
+
+	fmt.Println("retries exhausted")
+	attempts := 0
+	err = l.DoRetry(ctx, 1, func(c net.Conn) error {
+		attempts++
+		return failed
+	})
+	unittest.Equals(t, err, failed)
+	unittest.Equals(t, attempts, 2)
+
+	fmt.Println("closed")
+	l.Close()
+	err = l.DoRetry(ctx, 3, func(c net.Conn) error {
+		attempts++
+		return nil
+	})
+	unittest.Equals(t, errors.Is(err, ErrPoolClosed), true)
+	unittest.Equals(t, attempts, 2)
+	unittest.Equals(t, buffer.GetUsed(), 0)
+}
+func TestLagoonDoRetryFresh(t *testing.T) {
+	log.Println("TestLagoonDoRetryFresh")
+
+	buffer := CreateBuffer(3, time.Second)
+	unittest.NotNil(t, buffer)
+
+	dials := 0
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			dials++
+			return &fakeConnection{}, nil
+		},
+		Buffer: buffer,
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+	ctx := context.Background()
+
+	// our backend restarted, every idle connection is stale
+	fmt.Println("stale")
+	for i := 0; i < 3; i++ {
+		unittest.IsNil(t, l.DialInitialize())
+	}
+	stale := make(map[*pooledConn]bool)
+	for c, _ := range l.available {
+		stale[c] = true
+	}
+	unittest.Equals(t, len(stale), 3)
+
+	// retries never check out another stale connection
+	fmt.Println("retry fresh")
+	failed := fmt.Errorf("failed")
+	attempts := 0
+	err = l.DoRetry(ctx, 1, func(c net.Conn) error {
+		attempts++
+		if stale[c.(*Connection).c] {
+			return failed
+		}
+		return nil
+	})
+	unittest.IsNil(t, err)
+	unittest.Equals(t, attempts, 2)
+	unittest.Equals(t, dials, 4)
+	unittest.Equals(t, l.ConnectionsAvailable(), 3)
+
+	l.Close()
+	unittest.Equals(t, buffer.GetUsed(), 0)
+}
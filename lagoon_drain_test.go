@@ -0,0 +1,92 @@
+package lagoon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sabey.co/unittest"
+	"testing"
+	"time"
+)
+
+func TestLagoonDrain(t *testing.T) {
+	log.Println("TestLagoonDrain")
+
+	buffer := CreateBuffer(5, time.Second*2)
+	unittest.NotNil(t, buffer)
+
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			return &fakeConnection{}, nil
+		},
+		DialInitial: 3,
+		Buffer:      buffer,
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	c1, err := l.Dial()
+	unittest.IsNil(t, err)
+	c2, err := l.Dial()
+	unittest.IsNil(t, err)
+	unittest.Equals(t, l.ConnectionsAvailable(), 1)
+
+	// wait for active connections to be returned
+	fmt.Println("drain")
+	drained := make(chan error, 1)
+	go func() {
+		drained <- l.Drain(context.Background())
+	}()
+	for !l.IsClosed() {
+		<-time.After(time.Millisecond)
+	}
+	unittest.Equals(t, l.ConnectionsAvailable(), 0)
+	unittest.Equals(t, l.ConnectionsActive(), 2)
+	_, err = l.Dial()
+	unittest.Equals(t, errors.Is(err, ErrPoolClosed), true)
+
+	unittest.IsNil(t, c1.Close())
+	unittest.Equals(t, l.ConnectionsActive(), 1)
+	select {
+	case <-drained:
+		t.Fatal("drained early")
+	default:
+	}
+	unittest.IsNil(t, c2.Close())
+	unittest.IsNil(t, <-drained)
+	unittest.Equals(t, l.Connections(), 0)
+	unittest.Equals(t, buffer.GetUsed(), 0)
+}
+func TestLagoonDrainTimeout(t *testing.T) {
+	log.Println("TestLagoonDrainTimeout")
+
+	buffer := CreateBuffer(5, time.Second*2)
+	unittest.NotNil(t, buffer)
+
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			return &fakeConnection{}, nil
+		},
+		Buffer: buffer,
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	_, err = l.Dial()
+	unittest.IsNil(t, err)
+
+	// connections are forcibly closed once our context is done
+	fmt.Println("drain timeout")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	err = l.Drain(ctx)
+	unittest.Equals(t, errors.Is(err, context.DeadlineExceeded), true)
+	unittest.Equals(t, l.Connections(), 0)
+	unittest.Equals(t, buffer.GetUsed(), 0)
+}
@@ -0,0 +1,74 @@
+package lagoon
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sabey.co/unittest"
+	"testing"
+	"time"
+)
+
+func TestLagoonExpiry(t *testing.T) {
+	log.Println("TestLagoonExpiry")
+
+	buffer := CreateBuffer(5, time.Second*2)
+	unittest.NotNil(t, buffer)
+
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			return &fakeConnection{}, nil
+		},
+		Buffer:      buffer,
+		IdleTimeout: time.Millisecond * 100,
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	// nothing idle, nothing scheduled
+	l.mu.RLock()
+	unittest.Equals(t, l.timer_deadline.IsZero(), true)
+	l.mu.RUnlock()
+
+	fmt.Println("staggered idle")
+	unittest.IsNil(t, l.DialInitialize())
+	<-time.After(time.Millisecond * 60)
+	unittest.IsNil(t, l.DialInitialize())
+
+	// the timer is set to our oldest connection
+	l.mu.RLock()
+	unittest.Equals(t, len(l.expiry), 2)
+	unittest.Equals(t, l.timer_deadline.Equal(l.expiry[0].deadline), true)
+	l.mu.RUnlock()
+
+	// only the first connection has reached its deadline
+	<-time.After(time.Millisecond * 70)
+	unittest.Equals(t, l.ConnectionsAvailable(), 1)
+	unittest.Equals(t, l.Stats().IdleEvictions, int64(1))
+
+	fmt.Println("checkout leaves the heap")
+	c, err := l.Dial()
+	unittest.IsNil(t, err)
+	l.mu.RLock()
+	unittest.Equals(t, len(l.expiry), 0)
+	unittest.Equals(t, l.timer_deadline.IsZero(), true)
+	l.mu.RUnlock()
+	<-time.After(time.Millisecond * 150)
+	unittest.Equals(t, l.ConnectionsActive(), 1)
+
+	fmt.Println("return rejoins the heap")
+	unittest.IsNil(t, c.Close())
+	<-time.After(time.Millisecond * 50)
+	unittest.Equals(t, l.ConnectionsAvailable(), 1)
+	<-time.After(time.Millisecond * 100)
+	unittest.Equals(t, l.Connections(), 0)
+	unittest.Equals(t, l.Stats().IdleEvictions, int64(2))
+	unittest.Equals(t, buffer.GetUsed(), 0)
+
+	l.mu.RLock()
+	unittest.Equals(t, len(l.expiry), 0)
+	unittest.Equals(t, l.timer_deadline.IsZero(), true)
+	l.mu.RUnlock()
+}
@@ -0,0 +1,87 @@
+package lagoon
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sabey.co/unittest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLagoonHealth(t *testing.T) {
+	log.Println("TestLagoonHealth")
+
+	buffer := CreateBuffer(5, time.Second*2)
+	unittest.NotNil(t, buffer)
+
+	var dials, borrows, returns int32
+	healthy := int32(0)
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			return &fakeConnection{}, nil
+		},
+		DialInitial: 2,
+		Buffer:      buffer,
+		TestOnBorrow: func(c *Connection) error {
+			atomic.AddInt32(&borrows, 1)
+			if atomic.LoadInt32(&healthy) == 0 {
+				return fmt.Errorf("dead")
+			}
+			return nil
+		},
+		TestOnReturn: func(c *Connection) error {
+			atomic.AddInt32(&returns, 1)
+			if atomic.LoadInt32(&healthy) == 0 {
+				return fmt.Errorf("dead")
+			}
+			return nil
+		},
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+	unittest.Equals(t, l.ConnectionsAvailable(), 2)
+
+	// both idle connections fail, a fresh connection is dialed
+	fmt.Println("borrow unhealthy")
+	c, err := l.Dial()
+	unittest.IsNil(t, err)
+	unittest.Equals(t, atomic.LoadInt32(&borrows), int32(2))
+	unittest.Equals(t, atomic.LoadInt32(&dials), int32(3))
+	unittest.Equals(t, l.ConnectionsAvailable(), 0)
+	unittest.Equals(t, l.ConnectionsActive(), 1)
+	unittest.Equals(t, buffer.GetUsed(), 1)
+
+	// failing return is discarded
+	fmt.Println("return unhealthy")
+	err = c.Close()
+	unittest.NotNil(t, err)
+	_, ok := err.(*HealthCheckError)
+	unittest.Equals(t, ok, true)
+	unittest.Equals(t, atomic.LoadInt32(&returns), int32(1))
+	unittest.Equals(t, l.Connections(), 0)
+	unittest.Equals(t, buffer.GetUsed(), 0)
+
+	// healthy return
+	fmt.Println("return healthy")
+	atomic.StoreInt32(&healthy, 1)
+	c, err = l.Dial()
+	unittest.IsNil(t, err)
+	unittest.IsNil(t, c.Close())
+	unittest.Equals(t, l.ConnectionsAvailable(), 1)
+
+	// recently idle connections skip the borrow test
+	fmt.Println("test idle after")
+	l.config.TestIdleAfter = time.Minute
+	atomic.StoreInt32(&borrows, 0)
+	c, err = l.Dial()
+	unittest.IsNil(t, err)
+	unittest.Equals(t, atomic.LoadInt32(&borrows), int32(0))
+	unittest.IsNil(t, c.Close())
+	l.Close()
+	unittest.Equals(t, buffer.GetUsed(), 0)
+}
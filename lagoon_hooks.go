@@ -0,0 +1,79 @@
+package lagoon
+
+type hook struct {
+	fn func(*Connection)
+	c  *Connection
+}
+
+func (self *Lagoon) later(
+	fn func(*Connection),
+	c *pooledConn,
+) {
+	// assumed that we're locked
+	// hooks must never be called while we're locked, they will be called by unlock
+	if fn == nil {
+		return
+	}
+	// hooks see the handle of our latest checkout
+	self.hooks = append(self.hooks, hook{fn, c.handle})
+}
+func (self *Lagoon) unlock() {
+	// unlock and call any hooks that were queued while we were locked
+	hooks := self.hooks
+	self.hooks = nil
+	self.mu.Unlock()
+	for _, h := range hooks {
+		h.fn(h.c)
+	}
+}
+func (self *Lagoon) onDial(
+	c *Connection,
+) error {
+	if self.config.OnDial == nil {
+		return nil
+	}
+	return self.config.OnDial(c)
+}
+func (self *Lagoon) onDialError(
+	err error,
+) {
+	if self.config.OnDialError != nil {
+		self.config.OnDialError(err)
+	}
+}
+func (self *Lagoon) onCheckout(
+	c *Connection,
+) {
+	if self.config.OnCheckout != nil {
+		self.config.OnCheckout(c)
+	}
+}
+func (self *Lagoon) onReturn(
+	c *Connection,
+) {
+	if self.config.OnReturn != nil {
+		self.config.OnReturn(c)
+	}
+}
+func (self *Lagoon) onDisable(
+	c *Connection,
+) {
+	if self.config.OnDisable != nil {
+		self.config.OnDisable(c)
+	}
+}
+func (self *Lagoon) onClose(
+	c *Connection,
+	err error,
+) {
+	if self.config.OnClose != nil {
+		self.config.OnClose(c, err)
+	}
+}
+func (self *Lagoon) onBreaker(
+	state BreakerState,
+) {
+	if self.config.OnBreaker != nil {
+		self.config.OnBreaker(state)
+	}
+}
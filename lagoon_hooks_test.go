@@ -0,0 +1,164 @@
+package lagoon
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sabey.co/unittest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLagoonHooks(t *testing.T) {
+	log.Println("TestLagoonHooks")
+
+	buffer := CreateBuffer(5, time.Second*2)
+	unittest.NotNil(t, buffer)
+
+	var l *Lagoon
+	called := make(map[string]int)
+	locked := 0
+	var mu sync.Mutex
+	count := func(name string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return called[name]
+	}
+	record := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		// hooks must never be called while we're locked
+		if l.mu.TryLock() {
+			l.mu.Unlock()
+		} else {
+			locked++
+		}
+		called[name]++
+	}
+	refuse := false
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			return &fakeConnection{}, nil
+		},
+		Buffer: buffer,
+		OnDial: func(c *Connection) error {
+			record("dial")
+			if refuse {
+				return fmt.Errorf("auth failed")
+			}
+			return nil
+		},
+		OnDialError: func(err error) {
+			record("dial error")
+		},
+		OnCheckout: func(c *Connection) {
+			record("checkout")
+		},
+		OnReturn: func(c *Connection) {
+			record("return")
+		},
+		OnDisable: func(c *Connection) {
+			record("disable")
+		},
+		OnIdleEvict: func(c *Connection) {
+			record("idle evict")
+		},
+		OnClose: func(c *Connection, err error) {
+			record("close")
+		},
+	}
+
+	var err error
+	l, err = CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	fmt.Println("dial and return")
+	c, err := l.Dial()
+	unittest.IsNil(t, err)
+	unittest.IsNil(t, c.Close())
+	unittest.Equals(t, count("dial"), 1)
+	unittest.Equals(t, count("checkout"), 1)
+	unittest.Equals(t, count("return"), 1)
+
+	fmt.Println("disable")
+	c, err = l.Dial()
+	unittest.IsNil(t, err)
+	c.(*Connection).Disable()
+	unittest.IsNil(t, c.Close())
+	unittest.Equals(t, count("disable"), 1)
+	unittest.Equals(t, count("close"), 1)
+
+	fmt.Println("close available")
+	unittest.IsNil(t, l.DialInitialize())
+	l.CloseAvailable()
+	unittest.Equals(t, count("disable"), 2)
+	unittest.Equals(t, count("close"), 2)
+
+	fmt.Println("dial error")
+	refuse = true
+	_, err = l.Dial()
+	unittest.NotNil(t, err)
+	unittest.Equals(t, count("dial error"), 1)
+	unittest.Equals(t, buffer.GetUsed(), 0)
+	refuse = false
+
+	fmt.Println("idle evict")
+	l.config.IdleTimeout = time.Millisecond * 50
+	unittest.IsNil(t, l.DialInitialize())
+	for count("close") < 3 {
+		<-time.After(time.Millisecond * 10)
+	}
+	unittest.Equals(t, l.Connections(), 0)
+	unittest.Equals(t, count("idle evict"), 1)
+	unittest.Equals(t, count("close"), 3)
+	mu.Lock()
+	unittest.Equals(t, locked, 0)
+	mu.Unlock()
+}
+func TestLagoonHooksDial(t *testing.T) {
+	log.Println("TestLagoonHooksDial")
+
+	buffer := CreateBuffer(1, time.Second)
+	unittest.NotNil(t, buffer)
+
+	conn := &countingConnection{}
+	var dialed *Connection
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			return conn, nil
+		},
+		Buffer: buffer,
+		OnDial: func(c *Connection) error {
+			// authenticate through our Connection
+			dialed = c
+			unittest.IsNil(t, c.SetDeadline(time.Now().Add(time.Second)))
+			if _, err := c.Write([]byte("AUTH x\r\n")); err != nil {
+				return err
+			}
+			_, err := c.Read(make([]byte, 5))
+			return err
+		},
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	fmt.Println("auth")
+	c, err := l.Dial()
+	unittest.IsNil(t, err)
+	unittest.Equals(t, conn.writes, 1)
+	unittest.Equals(t, conn.reads, 1)
+
+	// OnDial's Connection is only lent to OnDial
+	fmt.Println("after OnDial")
+	_, err = dialed.Write(nil)
+	unittest.Equals(t, errors.Is(err, ErrConnReturned), true)
+	unittest.Equals(t, conn.writes, 1)
+	unittest.IsNil(t, c.Close())
+	l.Close()
+	unittest.Equals(t, buffer.GetUsed(), 0)
+}
@@ -0,0 +1,100 @@
+package lagoon
+
+import (
+	"runtime/debug"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// a connection that has been checked out for longer than Config.LeakThreshold
+type Leak struct {
+	Connection *Connection
+	Checkout   time.Time
+	Held       time.Duration
+	// the goroutine that called Dial
+	Stack string
+}
+
+func (self *Lagoon) checkedOut(
+	c *pooledConn,
+) {
+	// assumed that we're NOT locked
+	// called by the goroutine that called Dial so that we record the offending call site
+	if self.config.LeakThreshold <= 0 && self.config.MaxCheckoutDuration <= 0 {
+		return
+	}
+	var stack []byte
+	if self.config.LeakThreshold > 0 {
+		stack = debug.Stack()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checkout = time.Now()
+	c.stack = stack
+	uses := c.uses
+	if self.config.LeakThreshold > 0 {
+		c.leak_timer = time.AfterFunc(self.config.LeakThreshold, func() {
+			self.leaked(c, uses)
+		})
+	}
+	if self.config.MaxCheckoutDuration > 0 {
+		c.reclaim_timer = time.AfterFunc(self.config.MaxCheckoutDuration, func() {
+			self.reclaim(c, uses)
+		})
+	}
+}
+func (self *Lagoon) leaked(
+	c *pooledConn,
+	uses int,
+) {
+	self.mu.Lock()
+	c.mu.Lock()
+	if _, ok := self.active[c]; ok && c.uses == uses {
+		// still held by the same caller
+		atomic.AddInt64(&self.stats.leaks, 1)
+		self.later(self.config.OnLeak, c)
+	}
+	c.mu.Unlock()
+	self.unlock()
+}
+func (self *pooledConn) returned() {
+	// assumed that self is locked
+	// we're no longer checked out
+	if self.leak_timer != nil {
+		self.leak_timer.Stop()
+		self.leak_timer = nil
+	}
+	if self.reclaim_timer != nil {
+		self.reclaim_timer.Stop()
+		self.reclaim_timer = nil
+	}
+	self.checkout = time.Time{}
+	self.stack = nil
+}
+func (self *Lagoon) Leaks() []Leak {
+	// connections held for longer than Config.LeakThreshold, oldest first
+	if self.config.LeakThreshold <= 0 {
+		return nil
+	}
+	now := time.Now()
+	var leaks []Leak
+	self.mu.RLock()
+	for c, _ := range self.active {
+		c.mu.Lock()
+		if !c.checkout.IsZero() && now.Sub(c.checkout) >= self.config.LeakThreshold {
+			leaks = append(leaks, Leak{
+				Connection: c.handle,
+				Checkout:   c.checkout,
+				Held:       now.Sub(c.checkout),
+				Stack:      string(c.stack),
+			})
+		}
+		c.mu.Unlock()
+	}
+	self.mu.RUnlock()
+	sort.Slice(leaks, func(i, j int) bool {
+		return leaks[i].Checkout.Before(leaks[j].Checkout)
+	})
+	return leaks
+}
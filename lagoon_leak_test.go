@@ -0,0 +1,75 @@
+package lagoon
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sabey.co/unittest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLagoonLeak(t *testing.T) {
+	log.Println("TestLagoonLeak")
+
+	buffer := CreateBuffer(5, time.Second)
+	unittest.NotNil(t, buffer)
+
+	var mu sync.Mutex
+	var leaked []*Connection
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			return &fakeConnection{}, nil
+		},
+		Buffer:        buffer,
+		LeakThreshold: time.Millisecond * 50,
+		OnLeak: func(c *Connection) {
+			mu.Lock()
+			leaked = append(leaked, c)
+			mu.Unlock()
+		},
+	}
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(leaked)
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	fmt.Println("held")
+	c1, err := l.Dial()
+	unittest.IsNil(t, err)
+	c2, err := l.Dial()
+	unittest.IsNil(t, err)
+	unittest.Equals(t, len(l.Leaks()), 0)
+	// returned in time
+	unittest.IsNil(t, c2.Close())
+
+	fmt.Println("leaked")
+	<-time.After(time.Millisecond * 100)
+	unittest.Equals(t, count(), 1)
+	unittest.Equals(t, l.Stats().Leaks, int64(1))
+	leaks := l.Leaks()
+	unittest.Equals(t, len(leaks), 1)
+	unittest.Equals(t, leaks[0].Connection, c1.(*Connection))
+	unittest.Equals(t, leaks[0].Held >= time.Millisecond*50, true)
+	// our call site was recorded
+	unittest.Equals(t, strings.Contains(leaks[0].Stack, "TestLagoonLeak"), true)
+
+	fmt.Println("returned")
+	unittest.IsNil(t, c1.Close())
+	unittest.Equals(t, len(l.Leaks()), 0)
+
+	// a returned connection is never reported
+	c3, err := l.Dial()
+	unittest.IsNil(t, err)
+	unittest.IsNil(t, c3.Close())
+	<-time.After(time.Millisecond * 100)
+	unittest.Equals(t, count(), 1)
+	l.Close()
+}
@@ -0,0 +1,84 @@
+package lagoon
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sabey.co/unittest"
+	"testing"
+	"time"
+)
+
+func TestLagoonMaxUses(t *testing.T) {
+	log.Println("TestLagoonMaxUses")
+
+	buffer := CreateBuffer(5, time.Second*2)
+	unittest.NotNil(t, buffer)
+
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			return &fakeConnection{}, nil
+		},
+		Buffer:  buffer,
+		MaxUses: 2,
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	fmt.Println("first use")
+	c1, err := l.Dial()
+	unittest.IsNil(t, err)
+	unittest.IsNil(t, c1.Close())
+	unittest.Equals(t, l.ConnectionsAvailable(), 1)
+
+	fmt.Println("last use")
+	c2, err := l.Dial()
+	unittest.IsNil(t, err)
+	unittest.Equals(t, c2, c1)
+	unittest.IsNil(t, c2.Close())
+	unittest.Equals(t, l.Connections(), 0)
+	unittest.Equals(t, buffer.GetUsed(), 0)
+}
+func TestLagoonMaxLifetime(t *testing.T) {
+	log.Println("TestLagoonMaxLifetime")
+
+	buffer := CreateBuffer(5, time.Second*2)
+	unittest.NotNil(t, buffer)
+
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			return &fakeConnection{}, nil
+		},
+		Buffer:            buffer,
+		MaxLifetime:       time.Millisecond * 100,
+		MaxLifetimeJitter: time.Millisecond * 50,
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	// expired on return
+	fmt.Println("expire on return")
+	c, err := l.Dial()
+	unittest.IsNil(t, err)
+	<-time.After(time.Millisecond * 150)
+	unittest.IsNil(t, c.Close())
+	unittest.Equals(t, l.Connections(), 0)
+	unittest.Equals(t, buffer.GetUsed(), 0)
+
+	// expired while idle
+	fmt.Println("expire while idle")
+	unittest.IsNil(t, l.DialInitialize())
+	unittest.Equals(t, l.ConnectionsAvailable(), 1)
+	<-time.After(time.Millisecond * 300)
+	unittest.Equals(t, l.Connections(), 0)
+	unittest.Equals(t, buffer.GetUsed(), 0)
+
+	// invalid jitter
+	config.MaxLifetimeJitter = time.Second
+	_, err = CreateLagoon(config)
+	unittest.Equals(t, err, ERR_MAX_LIFETIME_JITTER)
+}
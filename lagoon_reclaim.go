@@ -0,0 +1,38 @@
+package lagoon
+
+import (
+	"sync/atomic"
+)
+
+func (self *Lagoon) reclaim(
+	c *pooledConn,
+	uses int,
+) {
+	// held for longer than MaxCheckoutDuration, take it back from its owner
+	self.mu.Lock()
+	c.mu.Lock()
+	if _, ok := self.active[c]; !ok || c.uses != uses {
+		// already returned
+		c.mu.Unlock()
+		self.unlock()
+		return
+	}
+	atomic.AddInt64(&self.stats.reclaims, 1)
+	// our owner's next Read or Write will fail
+	atomic.StoreInt32(&c.reclaimed, 1)
+	c.yanked = true
+	if !c.disabled {
+		atomic.AddInt64(&self.stats.disables, 1)
+		c.disabled = true
+		self.later(self.config.OnDisable, c)
+	}
+	// remove from lagoon
+	_, release := c.remove()
+	c.mu.Unlock()
+	self.unlock()
+	// close outside of our lock and release our buffer slot
+	c.discard(release)
+}
+func (self *pooledConn) isReclaimed() bool {
+	return atomic.LoadInt32(&self.reclaimed) == 1
+}
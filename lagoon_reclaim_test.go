@@ -0,0 +1,85 @@
+package lagoon
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sabey.co/unittest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLagoonReclaim(t *testing.T) {
+	log.Println("TestLagoonReclaim")
+
+	buffer := CreateBuffer(1, time.Second)
+	unittest.NotNil(t, buffer)
+
+	var closed int64
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			conn, _ := net.Pipe()
+			return conn, nil
+		},
+		Buffer:              buffer,
+		MaxCheckoutDuration: time.Millisecond * 50,
+		OnClose: func(c *Connection, err error) {
+			atomic.AddInt64(&closed, 1)
+		},
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	fmt.Println("stuck reading")
+	c1, err := l.Dial()
+	unittest.IsNil(t, err)
+	read := make(chan error, 1)
+	go func() {
+		_, err := c1.Read(make([]byte, 1))
+		read <- err
+	}()
+
+	// the buffer is full until we reclaim
+	dialed := make(chan net.Conn, 1)
+	go func() {
+		c, _ := l.Dial()
+		dialed <- c
+	}()
+
+	err = <-read
+	unittest.Equals(t, errors.Is(err, ErrReclaimed), true)
+	c2 := <-dialed
+	unittest.NotNil(t, c2)
+	unittest.Equals(t, l.Stats().Reclaims, int64(1))
+	unittest.Equals(t, buffer.GetUsed(), 1)
+
+	fmt.Println("owner")
+	_, err = c1.Write([]byte{1})
+	unittest.Equals(t, errors.Is(err, ErrReclaimed), true)
+	_, err = c1.Read(make([]byte, 1))
+	unittest.Equals(t, errors.Is(err, ErrReclaimed), true)
+	// closing late doesn't close us again or release somebody else's slot
+	unittest.Equals(t, l.Stats().Closes, int64(1))
+	err = c1.Close()
+	unittest.Equals(t, errors.Is(err, ErrReclaimed), true)
+	_, err = c1.Write([]byte{1})
+	unittest.Equals(t, errors.Is(err, ErrConnReturned), true)
+	err = c1.Close()
+	unittest.Equals(t, errors.Is(err, ErrConnReturned), true)
+	unittest.Equals(t, l.Stats().Closes, int64(1))
+	unittest.Equals(t, atomic.LoadInt64(&closed), int64(1))
+	unittest.Equals(t, buffer.GetUsed(), 1)
+	unittest.Equals(t, l.Stats().Invariants, int64(0))
+
+	fmt.Println("returned in time")
+	unittest.IsNil(t, c2.Close())
+	<-time.After(time.Millisecond * 100)
+	unittest.Equals(t, l.Stats().Reclaims, int64(1))
+	unittest.Equals(t, l.ConnectionsAvailable(), 1)
+	l.Close()
+	unittest.Equals(t, buffer.GetUsed(), 0)
+}
@@ -0,0 +1,104 @@
+package lagoon
+
+import (
+	"context"
+	"time"
+)
+
+func (self *Lagoon) wantRefill() {
+	// assumed that we're locked
+	// wake our refill goroutine if we've dropped below MinIdle or have been closed
+	if self.refill == nil {
+		return
+	}
+	if len(self.available) >= self.config.MinIdle && !self.closed {
+		return
+	}
+	select {
+	case self.refill <- struct{}{}:
+	default:
+		// already signaled
+	}
+}
+func (self *Lagoon) refiller() {
+	backoff := time.Duration(0)
+	delay := time.Duration(0)
+	for {
+		if delay > 0 {
+			// we failed to dial or our buffer is full, don't hammer our backend or buffer
+			// only being closed can interrupt our delay
+			timer := time.NewTimer(delay)
+			for waiting := true; waiting; {
+				select {
+				case <-timer.C:
+					waiting = false
+				case <-self.refill:
+					if self.IsClosed() {
+						timer.Stop()
+						return
+					}
+				}
+			}
+		} else {
+			<-self.refill
+		}
+		for {
+			self.mu.RLock()
+			closed := self.closed
+			need := self.config.MinIdle - len(self.available)
+			self.mu.RUnlock()
+			if closed {
+				// we never refill again
+				return
+			}
+			if need <= 0 {
+				backoff, delay = 0, 0
+				break
+			}
+			err := self.refillOne()
+			if err == nil {
+				backoff, delay = 0, 0
+				continue
+			}
+			if err == ERR_BUFFER_FULL {
+				// we didn't dial, our backend isn't failing
+				// retry shortly, a slot may be released at any time
+				delay = REFILL_BUFFER_DELAY
+				break
+			}
+			// back off
+			if backoff == 0 {
+				backoff = REFILL_BACKOFF_MIN
+			} else if backoff *= 2; backoff > REFILL_BACKOFF_MAX {
+				backoff = REFILL_BACKOFF_MAX
+			}
+			delay = backoff
+			break
+		}
+	}
+}
+func (self *Lagoon) refillOne() error {
+	// we respect our shared buffer, we never wait in line or jump ahead of waiting callers
+	// returns ERR_BUFFER_FULL if we couldn't acquire a slot
+	if !self.config.Buffer.tryAcquire() {
+		// only steal spare connections, lagoons refilling to MinIdle must not steal back and forth
+		if !self.config.Buffer.steal(self, true) || !self.config.Buffer.tryAcquire() {
+			return ERR_BUFFER_FULL
+		}
+	}
+	c, err := self.dialAcquired(context.Background())
+	if err != nil {
+		return err
+	}
+	// store in available, or hand to a waiting caller
+	self.mu.Lock()
+	if self.closed {
+		// we were closed while dialing
+		self.mu.Unlock()
+		c.discard(true)
+		return &PoolError{ErrPoolClosed}
+	}
+	self.put(c)
+	self.mu.Unlock()
+	return nil
+}
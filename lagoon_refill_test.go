@@ -0,0 +1,125 @@
+package lagoon
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sabey.co/unittest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLagoonMinIdle(t *testing.T) {
+	log.Println("TestLagoonMinIdle")
+
+	buffer := CreateBuffer(3, time.Second*2)
+	unittest.NotNil(t, buffer)
+
+	var dials, fail int32
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			if atomic.LoadInt32(&fail) == 1 {
+				return nil, fmt.Errorf("refused")
+			}
+			return &fakeConnection{}, nil
+		},
+		MinIdle: 2,
+		Buffer:  buffer,
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	wait := func(available int) {
+		for l.ConnectionsAvailable() != available {
+			<-time.After(time.Millisecond)
+		}
+	}
+
+	fmt.Println("warm")
+	wait(2)
+
+	// checkouts are refilled
+	fmt.Println("refill after checkout")
+	c, err := l.Dial()
+	unittest.IsNil(t, err)
+	wait(2)
+	unittest.Equals(t, l.Connections(), 3)
+
+	// refill respects our buffer
+	fmt.Println("refill respects buffer")
+	c2, err := l.Dial()
+	unittest.IsNil(t, err)
+	<-time.After(time.Millisecond * 50)
+	unittest.Equals(t, l.ConnectionsAvailable(), 1)
+	unittest.Equals(t, buffer.GetUsed(), 3)
+	unittest.IsNil(t, c.Close())
+	unittest.IsNil(t, c2.Close())
+
+	// failures back off
+	fmt.Println("back off")
+	atomic.StoreInt32(&fail, 1)
+	atomic.StoreInt32(&dials, 0)
+	l.CloseAvailable()
+	<-time.After(time.Millisecond * 250)
+	unittest.Equals(t, atomic.LoadInt32(&dials) <= 3, true)
+	unittest.Equals(t, l.ConnectionsAvailable(), 0)
+
+	fmt.Println("recover")
+	atomic.StoreInt32(&fail, 0)
+	wait(2)
+
+	fmt.Println("close")
+	l.Close()
+	<-time.After(time.Millisecond * 50)
+	unittest.Equals(t, l.Connections(), 0)
+	unittest.Equals(t, buffer.GetUsed(), 0)
+}
+func TestLagoonMinIdleBufferFull(t *testing.T) {
+	log.Println("TestLagoonMinIdleBufferFull")
+
+	buffer := CreateBuffer(1, time.Second*2)
+	unittest.NotNil(t, buffer)
+
+	var dials int32
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			return &fakeConnection{}, nil
+		},
+		Buffer: buffer,
+	}
+
+	a, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	c, err := a.Dial()
+	unittest.IsNil(t, err)
+
+	// another lagoon holds our only slot
+	b, err := CreateLagoon(&Config{
+		Dial:    config.Dial,
+		MinIdle: 1,
+		Buffer:  buffer,
+	})
+	unittest.IsNil(t, err)
+
+	// a full buffer isn't a dial failure, we never back off
+	fmt.Println("buffer full")
+	<-time.After(time.Second)
+	unittest.Equals(t, atomic.LoadInt32(&dials), int32(1))
+	unittest.Equals(t, b.ConnectionsAvailable(), 0)
+
+	fmt.Println("slot released")
+	unittest.IsNil(t, c.Close())
+	a.Close()
+	started := time.Now()
+	for b.ConnectionsAvailable() != 1 {
+		<-time.After(time.Millisecond)
+	}
+	unittest.Equals(t, time.Since(started) < REFILL_BACKOFF_MIN*2, true)
+	b.Close()
+	unittest.Equals(t, buffer.GetUsed(), 0)
+}
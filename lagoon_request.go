@@ -0,0 +1,132 @@
+package lagoon
+
+import (
+	"container/list"
+	"sync/atomic"
+	"time"
+)
+
+type connRequest struct {
+	// safe
+	conn chan *Connection
+	// unsafe
+	element *list.Element
+}
+
+func (self *Lagoon) request() *connRequest {
+	// assumed that we're locked
+	// queue a request for the next connection returned to the pool
+	req := &connRequest{
+		// buffered so that a handoff never blocks
+		conn: make(chan *Connection, 1),
+	}
+	req.element = self.requests.PushBack(req)
+	return req
+}
+func (self *Lagoon) cancelRequest(
+	req *connRequest,
+) *Connection {
+	// assumed that we're NOT locked
+	// returns a connection if one was handed to us before we could cancel
+	// a returned connection is active and MUST BE CLOSED
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if req.element != nil {
+		self.requests.Remove(req.element)
+		req.element = nil
+		return nil
+	}
+	// fulfilled, handoff already happened
+	select {
+	case c := <-req.conn:
+		// nil if we were closed
+		return c
+	default:
+		// handoffs always send before unlocking, this should never happen
+		atomic.AddInt64(&self.stats.invariants, 1)
+		return nil
+	}
+}
+func (self *Lagoon) handoff(
+	c *pooledConn,
+) bool {
+	// assumed that we're locked
+	// hand a connection to the oldest waiting caller
+	e := self.requests.Front()
+	if e == nil {
+		// nobody is waiting
+		return false
+	}
+	req := self.requests.Remove(e).(*connRequest)
+	req.element = nil
+	// store in active
+	req.conn <- self.activate(c)
+	return true
+}
+func (self *Lagoon) closeRequests() {
+	// assumed that we're locked
+	// waiting callers will receive a nil connection
+	for e := self.requests.Front(); e != nil; e = self.requests.Front() {
+		req := self.requests.Remove(e).(*connRequest)
+		req.element = nil
+		close(req.conn)
+	}
+}
+func (self *Lagoon) put(
+	c *pooledConn,
+) {
+	// assumed that we're locked
+	// connection must not be in available or active
+	if self.handoff(c) {
+		// remains active
+		// DO NOT RELEASE BUFFER!!!
+		return
+	}
+	// return to available
+	c.idle = time.Now()
+	self.pushAvailable(c)
+	// toggle tick
+	self.toggleTick()
+}
+func (self *Lagoon) oldestIdle(
+	spare bool,
+) (
+	time.Time,
+	bool,
+) {
+	// when did our oldest available connection become idle?
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	oldest := self.oldestAvailable()
+	if oldest == nil || (spare && !self.hasSpare()) {
+		return time.Time{}, false
+	}
+	return oldest.idle, true
+}
+func (self *Lagoon) hasSpare() bool {
+	// assumed that we're locked
+	// are we holding more available connections than MinIdle?
+	return len(self.available) > self.config.MinIdle
+}
+func (self *Lagoon) evictIdle(
+	spare bool,
+) bool {
+	// evict our oldest available connection so that another lagoon can use its slot
+	self.mu.Lock()
+	oldest := self.oldestAvailable()
+	if oldest == nil || (spare && !self.hasSpare()) {
+		// somebody beat us to it
+		self.unlock()
+		return false
+	}
+	atomic.AddInt64(&self.stats.steals, 1)
+	self.later(self.config.OnIdleEvict, oldest)
+	oldest.mu.Lock()
+	oldest.disabled = true
+	oldest.remove()
+	oldest.mu.Unlock()
+	self.unlock()
+	// close outside of our lock, releasing our slot
+	oldest.discard(true)
+	return true
+}
@@ -0,0 +1,71 @@
+package lagoon
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sabey.co/unittest"
+	"testing"
+	"time"
+)
+
+func TestLagoonHandoff(t *testing.T) {
+	log.Println("TestLagoonHandoff")
+
+	buffer := CreateBuffer(1, time.Second*5)
+	unittest.NotNil(t, buffer)
+
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			return &fakeConnection{}, nil
+		},
+		Buffer: buffer,
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	c, err := l.Dial()
+	unittest.IsNil(t, err)
+
+	// wait on a full pool
+	fmt.Println("wait on full pool")
+	handed := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.Dial()
+		if err == nil {
+			handed <- c
+		}
+		close(handed)
+	}()
+	for {
+		l.mu.RLock()
+		waiting := l.requests.Len()
+		l.mu.RUnlock()
+		if waiting == 1 {
+			break
+		}
+		<-time.After(time.Millisecond)
+	}
+
+	// returned connection goes straight to the waiter
+	fmt.Println("return connection")
+	started := time.Now()
+	unittest.IsNil(t, c.Close())
+	h := <-handed
+	unittest.Equals(t, h, c)
+	unittest.Equals(t, time.Since(started) < time.Second, true)
+	unittest.Equals(t, l.ConnectionsAvailable(), 0)
+	unittest.Equals(t, l.ConnectionsActive(), 1)
+	unittest.Equals(t, buffer.GetUsed(), 1)
+	unittest.Equals(t, buffer.GetWaiting(), 0)
+	l.mu.RLock()
+	unittest.Equals(t, l.requests.Len(), 0)
+	l.mu.RUnlock()
+
+	unittest.IsNil(t, h.Close())
+	unittest.Equals(t, l.ConnectionsAvailable(), 1)
+	l.Close()
+	unittest.Equals(t, buffer.GetUsed(), 0)
+}
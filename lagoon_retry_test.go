@@ -0,0 +1,151 @@
+package lagoon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sabey.co/unittest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryValidate(t *testing.T) {
+	log.Println("TestRetryValidate")
+
+	var retry *Retry
+	unittest.IsNil(t, retry.Validate())
+	unittest.IsNil(t, retry.Clone())
+
+	retry = &Retry{}
+	unittest.Equals(t, retry.Validate(), ERR_RETRY_ATTEMPTS)
+	retry.Attempts = 3
+	retry.Backoff = -1
+	unittest.Equals(t, retry.Validate(), ERR_RETRY_BACKOFF)
+	retry.Backoff = time.Second
+	retry.BackoffMax = time.Millisecond
+	unittest.Equals(t, retry.Validate(), ERR_RETRY_BACKOFF_MAX)
+	retry.BackoffMax = 0
+	retry.Jitter = -1
+	unittest.Equals(t, retry.Validate(), ERR_RETRY_JITTER)
+	retry.Jitter = 0
+	unittest.IsNil(t, retry.Validate())
+
+	fmt.Println("exponential backoff")
+	retry = &Retry{
+		Attempts:   10,
+		Backoff:    time.Millisecond,
+		BackoffMax: time.Millisecond * 6,
+	}
+	for attempt, expected := range []time.Duration{
+		time.Millisecond,
+		time.Millisecond * 2,
+		time.Millisecond * 4,
+		time.Millisecond * 6,
+		time.Millisecond * 6,
+	} {
+		wait, ok := retry.next(attempt+1, ERR_DIAL_EMPTY)
+		unittest.Equals(t, ok, true)
+		unittest.Equals(t, wait, expected)
+	}
+	_, ok := retry.next(10, ERR_DIAL_EMPTY)
+	unittest.Equals(t, ok, false)
+	_, ok = retry.next(1, &ContextError{context.Canceled})
+	unittest.Equals(t, ok, false)
+
+	fmt.Println("jitter")
+	retry.Jitter = time.Millisecond
+	for i := 0; i < 100; i++ {
+		wait, _ := retry.next(1, ERR_DIAL_EMPTY)
+		unittest.Equals(t, wait >= time.Millisecond && wait <= time.Millisecond*2, true)
+	}
+}
+func TestLagoonRetry(t *testing.T) {
+	log.Println("TestLagoonRetry")
+
+	buffer := CreateBuffer(1, time.Second)
+	unittest.NotNil(t, buffer)
+
+	permanent := fmt.Errorf("permanent")
+	var mu sync.Mutex
+	failures := 0
+	var failure error
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if failures > 0 {
+				failures--
+				return nil, failure
+			}
+			return &fakeConnection{}, nil
+		},
+		Buffer: buffer,
+		Retry: &Retry{
+			Attempts: 3,
+			Backoff:  time.Millisecond * 10,
+			Retryable: func(err error) bool {
+				return !errors.Is(err, permanent)
+			},
+		},
+	}
+	fail := func(
+		n int,
+		err error,
+	) {
+		mu.Lock()
+		failures = n
+		failure = err
+		mu.Unlock()
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	fmt.Println("retry until dialed")
+	fail(2, fmt.Errorf("refused"))
+	c, err := l.Dial()
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, c)
+	stats := l.Stats()
+	unittest.Equals(t, stats.Dials, int64(3))
+	unittest.Equals(t, stats.DialsFailed, int64(2))
+	unittest.Equals(t, stats.DialRetries, int64(2))
+	// we held a single buffer slot throughout
+	unittest.Equals(t, buffer.GetUsed(), 1)
+	unittest.Equals(t, buffer.GetWaiting(), 0)
+	c.(*Connection).Disable()
+	unittest.IsNil(t, c.Close())
+	unittest.Equals(t, buffer.GetUsed(), 0)
+
+	fmt.Println("give up after attempts")
+	fail(3, fmt.Errorf("refused"))
+	_, err = l.Dial()
+	var e *DialError
+	unittest.Equals(t, errors.As(err, &e), true)
+	unittest.Equals(t, l.Stats().DialRetries, int64(4))
+	unittest.Equals(t, buffer.GetUsed(), 0)
+
+	fmt.Println("not retryable")
+	fail(1, permanent)
+	_, err = l.Dial()
+	unittest.Equals(t, errors.Is(err, permanent), true)
+	unittest.Equals(t, l.Stats().DialRetries, int64(4))
+	unittest.Equals(t, buffer.GetUsed(), 0)
+
+	fmt.Println("backoff past our deadline")
+	l.config.Retry.Backoff = time.Second
+	fail(1, fmt.Errorf("refused"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	started := time.Now()
+	_, err = l.DialContext(ctx)
+	unittest.Equals(t, errors.As(err, &e), true)
+	unittest.Equals(t, time.Since(started) < time.Millisecond*50, true)
+	unittest.Equals(t, l.Stats().DialRetries, int64(4))
+	unittest.Equals(t, buffer.GetUsed(), 0)
+	l.Close()
+}
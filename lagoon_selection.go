@@ -0,0 +1,79 @@
+package lagoon
+
+import (
+	"math/rand"
+)
+
+// which available connection Dial will hand out
+type Selection int
+
+const (
+	// any available connection
+	SELECTION_RANDOM Selection = iota
+	// the most recently returned connection, hot connections stay warm and extras idle out
+	SELECTION_LIFO
+	// the longest idle connection, spreads wear across every connection
+	SELECTION_FIFO
+	// the connection checked out the fewest times, balances load across server side sessions
+	SELECTION_LEAST_USED
+)
+
+func (self Selection) IsValid() bool {
+	return self >= SELECTION_RANDOM && self <= SELECTION_LEAST_USED
+}
+func (self *Lagoon) pushAvailable(
+	c *pooledConn,
+) {
+	// assumed that we're locked
+	// idle is ordered from the longest idle at the front to the most recently returned at the back
+	self.available[c] = self.idle.PushBack(c)
+	self.pushExpiry(c)
+}
+func (self *Lagoon) removeAvailable(
+	c *pooledConn,
+) bool {
+	// assumed that we're locked
+	e, ok := self.available[c]
+	if !ok {
+		return false
+	}
+	self.idle.Remove(e)
+	self.removeExpiry(c)
+	delete(self.available, c)
+	return true
+}
+func (self *Lagoon) oldestAvailable() *pooledConn {
+	// assumed that we're locked
+	if e := self.idle.Front(); e != nil {
+		return e.Value.(*pooledConn)
+	}
+	return nil
+}
+func (self *Lagoon) selectAvailable() *pooledConn {
+	// assumed that we're locked
+	if self.idle.Len() == 0 {
+		return nil
+	}
+	switch self.config.Selection {
+	case SELECTION_LIFO:
+		return self.idle.Back().Value.(*pooledConn)
+	case SELECTION_FIFO:
+		return self.idle.Front().Value.(*pooledConn)
+	case SELECTION_LEAST_USED:
+		// prefer the most recently returned when tied
+		var least *pooledConn
+		for e := self.idle.Back(); e != nil; e = e.Prev() {
+			if c := e.Value.(*pooledConn); least == nil || c.uses < least.uses {
+				least = c
+			}
+		}
+		return least
+	}
+	// random
+	i := rand.Intn(self.idle.Len())
+	e := self.idle.Front()
+	for ; i > 0; i-- {
+		e = e.Next()
+	}
+	return e.Value.(*pooledConn)
+}
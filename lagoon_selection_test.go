@@ -0,0 +1,74 @@
+package lagoon
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sabey.co/unittest"
+	"testing"
+	"time"
+)
+
+func TestLagoonSelection(t *testing.T) {
+	log.Println("TestLagoonSelection")
+
+	buffer := CreateBuffer(3, time.Second*2)
+	unittest.NotNil(t, buffer)
+
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			return &fakeConnection{}, nil
+		},
+		Buffer:    buffer,
+		Selection: SELECTION_LIFO,
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	// return in order
+	c1, _ := l.Dial()
+	c2, _ := l.Dial()
+	c3, _ := l.Dial()
+	c1.Close()
+	c2.Close()
+	c3.Close()
+
+	fmt.Println("lifo")
+	c, err := l.Dial()
+	unittest.IsNil(t, err)
+	unittest.Equals(t, c, c3)
+	c.Close()
+
+	fmt.Println("fifo")
+	l.config.Selection = SELECTION_FIFO
+	c, err = l.Dial()
+	unittest.IsNil(t, err)
+	unittest.Equals(t, c, c1)
+	c.Close()
+
+	// c1 and c3 have been used twice
+	fmt.Println("least used")
+	l.config.Selection = SELECTION_LEAST_USED
+	c, err = l.Dial()
+	unittest.IsNil(t, err)
+	unittest.Equals(t, c, c2)
+	c.Close()
+
+	fmt.Println("random")
+	l.config.Selection = SELECTION_RANDOM
+	c, err = l.Dial()
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, c)
+	c.Close()
+	unittest.Equals(t, l.ConnectionsAvailable(), 3)
+	unittest.Equals(t, l.idle.Len(), 3)
+
+	fmt.Println("invalid")
+	config.Selection = Selection(100)
+	_, err = CreateLagoon(config)
+	unittest.Equals(t, err, ERR_SELECTION)
+	l.Close()
+	unittest.Equals(t, l.idle.Len(), 0)
+}
@@ -0,0 +1,169 @@
+package lagoon
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type Stats struct {
+	// current
+	Connections int
+	Available   int
+	Active      int
+	Waiting     int
+	Closed      bool
+	Breaker     BreakerState
+	// cumulative
+	Dials             int64
+	DialsSucceeded    int64
+	DialsFailed       int64
+	DialRetries       int64
+	Timeouts          int64
+	IdleEvictions     int64
+	Steals            int64
+	Expirations       int64
+	Disables          int64
+	Closes            int64
+	BreakerOpens      int64
+	BreakerRejections int64
+	Leaks             int64
+	Reclaims          int64
+	Waits             int64
+	WaitDuration      time.Duration
+	MaxWaitDuration   time.Duration
+	// internal states that should never happen
+	Invariants int64
+	// distributions
+	WaitHistogram Histogram
+	DialHistogram Histogram
+}
+
+type Histogram struct {
+	// upper bounds of each bucket
+	// Counts has one more bucket than Buckets for observations above the last bound
+	Buckets []time.Duration
+	// non-cumulative
+	Counts []int64
+	Count  int64
+	Sum    time.Duration
+}
+
+var histogram_buckets = [...]time.Duration{
+	time.Millisecond,
+	time.Millisecond * 5,
+	time.Millisecond * 10,
+	time.Millisecond * 25,
+	time.Millisecond * 50,
+	time.Millisecond * 100,
+	time.Millisecond * 250,
+	time.Millisecond * 500,
+	time.Second,
+	time.Millisecond * 2500,
+	time.Second * 5,
+	time.Second * 10,
+}
+
+type histogram struct {
+	// safe
+	// must only be accessed atomically
+	counts [len(histogram_buckets) + 1]int64
+	sum    int64
+}
+
+func (self *histogram) observe(
+	d time.Duration,
+) {
+	i := 0
+	for ; i < len(histogram_buckets); i++ {
+		if d <= histogram_buckets[i] {
+			break
+		}
+	}
+	atomic.AddInt64(&self.counts[i], 1)
+	atomic.AddInt64(&self.sum, int64(d))
+}
+func (self *histogram) snapshot() Histogram {
+	h := Histogram{
+		Buckets: make([]time.Duration, len(histogram_buckets)),
+		Counts:  make([]int64, len(self.counts)),
+		Sum:     time.Duration(atomic.LoadInt64(&self.sum)),
+	}
+	copy(h.Buckets, histogram_buckets[:])
+	for i := range self.counts {
+		h.Counts[i] = atomic.LoadInt64(&self.counts[i])
+		h.Count += h.Counts[i]
+	}
+	return h
+}
+
+type lagoonStats struct {
+	// safe
+	// must only be accessed atomically
+	dials              int64
+	dials_succeeded    int64
+	dials_failed       int64
+	dial_retries       int64
+	timeouts           int64
+	idle_evictions     int64
+	steals             int64
+	expirations        int64
+	disables           int64
+	closes             int64
+	breaker_opens      int64
+	breaker_rejections int64
+	leaks              int64
+	reclaims           int64
+	waits              int64
+	wait_duration      int64
+	wait_max           int64
+	invariants         int64
+	wait_histogram     histogram
+	dial_histogram     histogram
+}
+
+func (self *lagoonStats) waited(
+	d time.Duration,
+) {
+	atomic.AddInt64(&self.waits, 1)
+	atomic.AddInt64(&self.wait_duration, int64(d))
+	self.wait_histogram.observe(d)
+	for {
+		max := atomic.LoadInt64(&self.wait_max)
+		if int64(d) <= max || atomic.CompareAndSwapInt64(&self.wait_max, max, int64(d)) {
+			return
+		}
+	}
+}
+func (self *Lagoon) Stats() Stats {
+	self.mu.RLock()
+	stats := Stats{
+		Connections: len(self.available) + len(self.active),
+		Available:   len(self.available),
+		Active:      len(self.active),
+		Waiting:     self.requests.Len(),
+		Closed:      self.closed,
+	}
+	self.mu.RUnlock()
+	stats.Breaker = self.breaker.getState()
+	stats.Dials = atomic.LoadInt64(&self.stats.dials)
+	stats.DialsSucceeded = atomic.LoadInt64(&self.stats.dials_succeeded)
+	stats.DialsFailed = atomic.LoadInt64(&self.stats.dials_failed)
+	stats.DialRetries = atomic.LoadInt64(&self.stats.dial_retries)
+	stats.Timeouts = atomic.LoadInt64(&self.stats.timeouts)
+	stats.IdleEvictions = atomic.LoadInt64(&self.stats.idle_evictions)
+	stats.Steals = atomic.LoadInt64(&self.stats.steals)
+	stats.Expirations = atomic.LoadInt64(&self.stats.expirations)
+	stats.Disables = atomic.LoadInt64(&self.stats.disables)
+	stats.Closes = atomic.LoadInt64(&self.stats.closes)
+	stats.BreakerOpens = atomic.LoadInt64(&self.stats.breaker_opens)
+	stats.BreakerRejections = atomic.LoadInt64(&self.stats.breaker_rejections)
+	stats.Leaks = atomic.LoadInt64(&self.stats.leaks)
+	stats.Reclaims = atomic.LoadInt64(&self.stats.reclaims)
+	stats.Waits = atomic.LoadInt64(&self.stats.waits)
+	stats.WaitDuration = time.Duration(atomic.LoadInt64(&self.stats.wait_duration))
+	stats.MaxWaitDuration = time.Duration(atomic.LoadInt64(&self.stats.wait_max))
+	stats.Invariants = atomic.LoadInt64(&self.stats.invariants)
+	stats.WaitHistogram = self.stats.wait_histogram.snapshot()
+	stats.DialHistogram = self.stats.dial_histogram.snapshot()
+	return stats
+}
@@ -0,0 +1,99 @@
+package lagoon
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sabey.co/unittest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLagoonStats(t *testing.T) {
+	log.Println("TestLagoonStats")
+
+	buffer := CreateBuffer(1, time.Millisecond*50)
+	unittest.NotNil(t, buffer)
+
+	var fail int32
+	config := &Config{
+		Dial: func() (net.Conn, error) {
+			if atomic.LoadInt32(&fail) == 1 {
+				return nil, fmt.Errorf("refused")
+			}
+			return &fakeConnection{}, nil
+		},
+		Buffer: buffer,
+	}
+
+	l, err := CreateLagoon(config)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, l)
+
+	fmt.Println("dial")
+	c, err := l.Dial()
+	unittest.IsNil(t, err)
+
+	fmt.Println("timeout")
+	_, err = l.Dial()
+	unittest.NotNil(t, err)
+
+	stats := l.Stats()
+	unittest.Equals(t, stats.Connections, 1)
+	unittest.Equals(t, stats.Active, 1)
+	unittest.Equals(t, stats.Available, 0)
+	unittest.Equals(t, stats.Waiting, 0)
+	unittest.Equals(t, stats.Dials, int64(1))
+	unittest.Equals(t, stats.DialsSucceeded, int64(1))
+	unittest.Equals(t, stats.DialsFailed, int64(0))
+	unittest.Equals(t, stats.Timeouts, int64(1))
+	unittest.Equals(t, stats.Waits, int64(2))
+	unittest.Equals(t, stats.MaxWaitDuration >= time.Millisecond*50, true)
+	unittest.Equals(t, stats.WaitDuration >= stats.MaxWaitDuration, true)
+
+	fmt.Println("disable")
+	c.(*Connection).Disable()
+	unittest.IsNil(t, c.Close())
+
+	fmt.Println("dial failed")
+	atomic.StoreInt32(&fail, 1)
+	_, err = l.Dial()
+	unittest.NotNil(t, err)
+
+	stats = l.Stats()
+	unittest.Equals(t, stats.Connections, 0)
+	unittest.Equals(t, stats.Dials, int64(2))
+	unittest.Equals(t, stats.DialsFailed, int64(1))
+	unittest.Equals(t, stats.Disables, int64(1))
+	unittest.Equals(t, stats.Closes, int64(1))
+	unittest.Equals(t, stats.Invariants, int64(0))
+
+	// a dial that returns nothing must not be handed out
+	fmt.Println("dial empty")
+	l.config.Dial = func() (net.Conn, error) {
+		return nil, nil
+	}
+	_, err = l.Dial()
+	unittest.Equals(t, errors.Is(err, ERR_DIAL_EMPTY), true)
+	stats = l.Stats()
+	unittest.Equals(t, stats.Invariants, int64(1))
+	unittest.Equals(t, stats.DialsFailed, int64(2))
+	unittest.Equals(t, buffer.GetUsed(), 0)
+
+	// checkouts from available are waits too
+	fmt.Println("available waits")
+	l.config.Dial = func() (net.Conn, error) {
+		return &fakeConnection{}, nil
+	}
+	for i := 0; i < 5; i++ {
+		c, err = l.Dial()
+		unittest.IsNil(t, err)
+		unittest.IsNil(t, c.Close())
+	}
+	waits := l.Stats()
+	unittest.Equals(t, waits.Dials, stats.Dials+1)
+	unittest.Equals(t, waits.Waits, stats.Waits+5)
+	unittest.Equals(t, waits.WaitHistogram.Count, stats.WaitHistogram.Count+5)
+}
@@ -1,6 +1,7 @@
 package lagoon
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -60,9 +61,9 @@ func TestLagoon(t *testing.T) {
 	fmt.Println("dial timeout")
 	_, err = l.Dial()
 	unittest.NotNil(t, err)
-	e, ok := err.(*dialError)
+	e, ok := err.(*PoolError)
 	unittest.Equals(t, ok, true)
-	unittest.Equals(t, e.error, ERR_TIMEDOUT)
+	unittest.Equals(t, e.Err, ERR_TIMEDOUT)
 	unittest.Equals(t, e.Temporary(), true)
 	unittest.Equals(t, e.Timeout(), true)
 	unittest.Equals(t, len(l.available), 0)
@@ -94,8 +95,9 @@ func TestLagoon(t *testing.T) {
 	unittest.Equals(t, l.Connections(), config.Buffer.GetMax())
 
 	// close
-	fmt.Println("l.Close")
-	l.Close()
+	fmt.Println("l.CloseAvailable + l.CloseActive")
+	l.CloseAvailable()
+	l.CloseActive()
 	fmt.Println("closed")
 	unittest.Equals(t, len(l.available), 0)
 	unittest.Equals(t, l.ConnectionsAvailable(), 0)
@@ -150,8 +152,8 @@ func TestLagoon(t *testing.T) {
 	// disable + close
 	fmt.Println("disable + active c.Close")
 	for c, _ := range l.active {
-		c.Disable()
-		c.Close()
+		c.handle.Disable()
+		c.handle.Close()
 	}
 	unittest.Equals(t, len(l.available), 0)
 	unittest.Equals(t, l.ConnectionsAvailable(), 0)
@@ -176,7 +178,7 @@ func TestLagoon(t *testing.T) {
 	// close
 	fmt.Println("active c.Close")
 	for c, _ := range l.active {
-		c.Close()
+		c.handle.Close()
 	}
 	unittest.Equals(t, len(l.available), config.Buffer.GetMax())
 	unittest.Equals(t, l.ConnectionsAvailable(), config.Buffer.GetMax())
@@ -185,8 +187,8 @@ func TestLagoon(t *testing.T) {
 	unittest.Equals(t, len(l.available)+len(l.active), config.Buffer.GetMax())
 	unittest.Equals(t, l.Connections(), config.Buffer.GetMax())
 
-	fmt.Println("l.Close")
-	l.Close()
+	fmt.Println("l.CloseAvailable")
+	l.CloseAvailable()
 
 	// DialInitialize max
 	fmt.Println("DialInitialize max")
@@ -204,7 +206,7 @@ func TestLagoon(t *testing.T) {
 	// disable + close
 	fmt.Println("disable + available c.Close")
 	for c, _ := range l.available {
-		c.Close()
+		c.close(nil)
 	}
 	unittest.Equals(t, len(l.available), 0)
 	unittest.Equals(t, l.ConnectionsAvailable(), 0)
@@ -229,8 +231,8 @@ func TestLagoon(t *testing.T) {
 	// close
 	fmt.Println("available c.Close")
 	for c, _ := range l.available {
-		c.Disable()
-		c.Close()
+		c.disable()
+		c.close(nil)
 	}
 	unittest.Equals(t, len(l.available), 0)
 	unittest.Equals(t, l.ConnectionsAvailable(), 0)
@@ -238,6 +240,15 @@ func TestLagoon(t *testing.T) {
 	unittest.Equals(t, l.ConnectionsActive(), 0)
 	unittest.Equals(t, len(l.available)+len(l.active), 0)
 	unittest.Equals(t, l.Connections(), 0)
+
+	// close
+	fmt.Println("l.Close")
+	l.Close()
+	unittest.Equals(t, l.IsClosed(), true)
+	_, err = l.Dial()
+	unittest.Equals(t, errors.Is(err, ErrPoolClosed), true)
+	unittest.Equals(t, errors.Is(l.DialInitialize(), ErrPoolClosed), true)
+	unittest.Equals(t, l.Connections(), 0)
 }
 func TestLagoonIdle(t *testing.T) {
 	log.Println("TestLagoonIdle")
@@ -262,13 +273,11 @@ func TestLagoonIdle(t *testing.T) {
 	unittest.IsNil(t, err)
 	unittest.NotNil(t, l)
 
-	// overwrite internal tickevery and idle
-	l.config.TickEvery = time.Millisecond * 500
+	// overwrite internal idle
 	l.config.IdleTimeout = time.Second * 2
 
 	l.mu.Lock()
-	unittest.Equals(t, l.ticker_running.IsZero(), true)
-	unittest.Equals(t, l.ticker_stop, false)
+	unittest.Equals(t, l.timer_deadline.IsZero(), true)
 	l.mu.Unlock()
 
 	// allocate connections
@@ -284,8 +293,7 @@ func TestLagoonIdle(t *testing.T) {
 
 	// check ticker
 	l.mu.Lock()
-	unittest.Equals(t, l.ticker_running.IsZero(), false)
-	unittest.Equals(t, l.ticker_stop, false)
+	unittest.Equals(t, l.timer_deadline.IsZero(), false)
 	l.mu.Unlock()
 	unittest.Equals(t, l.ConnectionsAvailable(), 5)
 	unittest.Equals(t, l.ConnectionsActive(), 0)
@@ -298,8 +306,7 @@ func TestLagoonIdle(t *testing.T) {
 
 	// check ticker
 	l.mu.Lock()
-	unittest.Equals(t, l.ticker_running.IsZero(), true)
-	unittest.Equals(t, l.ticker_stop, false)
+	unittest.Equals(t, l.timer_deadline.IsZero(), true)
 	l.mu.Unlock()
 	unittest.Equals(t, l.ConnectionsAvailable(), 0)
 	unittest.Equals(t, l.ConnectionsActive(), 0)
@@ -320,8 +327,7 @@ func TestLagoonIdle(t *testing.T) {
 
 	// check ticker
 	l.mu.Lock()
-	unittest.Equals(t, l.ticker_running.IsZero(), false)
-	unittest.Equals(t, l.ticker_stop, false)
+	unittest.Equals(t, l.timer_deadline.IsZero(), false)
 	l.mu.Unlock()
 	unittest.Equals(t, l.ConnectionsAvailable(), 4)
 	unittest.Equals(t, l.ConnectionsActive(), 1)
@@ -334,8 +340,7 @@ func TestLagoonIdle(t *testing.T) {
 
 	// check ticker
 	l.mu.Lock()
-	unittest.Equals(t, l.ticker_running.IsZero(), true)
-	unittest.Equals(t, l.ticker_stop, false)
+	unittest.Equals(t, l.timer_deadline.IsZero(), true)
 	l.mu.Unlock()
 	unittest.Equals(t, l.ConnectionsAvailable(), 0)
 	unittest.Equals(t, l.ConnectionsActive(), 1)
@@ -346,8 +351,7 @@ func TestLagoonIdle(t *testing.T) {
 
 	// check ticker
 	l.mu.Lock()
-	unittest.Equals(t, l.ticker_running.IsZero(), false)
-	unittest.Equals(t, l.ticker_stop, false)
+	unittest.Equals(t, l.timer_deadline.IsZero(), false)
 	l.mu.Unlock()
 	unittest.Equals(t, l.ConnectionsAvailable(), 1)
 	unittest.Equals(t, l.ConnectionsActive(), 0)
@@ -360,8 +364,7 @@ func TestLagoonIdle(t *testing.T) {
 
 	// check ticker
 	l.mu.Lock()
-	unittest.Equals(t, l.ticker_running.IsZero(), true)
-	unittest.Equals(t, l.ticker_stop, false)
+	unittest.Equals(t, l.timer_deadline.IsZero(), true)
 	l.mu.Unlock()
 	unittest.Equals(t, l.ConnectionsAvailable(), 0)
 	unittest.Equals(t, l.ConnectionsActive(), 0)
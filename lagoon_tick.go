@@ -1,56 +1,131 @@
 package lagoon
 
 import (
+	"container/heap"
+	"sync/atomic"
 	"time"
 )
 
-func (self *Lagoon) toggleTick() {
-	if self.config.IdleTimeout < 1 {
-		// we don't tick
+// available connections ordered by their deadline, the next to expire is at the top
+type expiryHeap []*pooledConn
+
+func (self expiryHeap) Len() int {
+	return len(self)
+}
+func (self expiryHeap) Less(
+	i int,
+	j int,
+) bool {
+	return self[i].deadline.Before(self[j].deadline)
+}
+func (self expiryHeap) Swap(
+	i int,
+	j int,
+) {
+	self[i], self[j] = self[j], self[i]
+	self[i].index = i
+	self[j].index = j
+}
+func (self *expiryHeap) Push(
+	x interface{},
+) {
+	c := x.(*pooledConn)
+	c.index = len(*self)
+	*self = append(*self, c)
+}
+func (self *expiryHeap) Pop() interface{} {
+	old := *self
+	n := len(old)
+	c := old[n-1]
+	old[n-1] = nil
+	c.index = -1
+	*self = old[:n-1]
+	return c
+}
+func (self *Lagoon) pushExpiry(
+	c *pooledConn,
+) {
+	// assumed that we're locked
+	// our deadline is whichever comes first, idling out or outliving MaxLifetime
+	c.deadline = time.Time{}
+	if self.config.IdleTimeout > 0 {
+		c.deadline = c.idle.Add(self.config.IdleTimeout)
+	}
+	if !c.expires.IsZero() && (c.deadline.IsZero() || c.expires.Before(c.deadline)) {
+		c.deadline = c.expires
+	}
+	if c.deadline.IsZero() {
+		// we never expire while idle
 		return
 	}
-	if len(self.available) == 0 {
-		self.ticker_stop = true
-	} else {
-		// idle connections exist
-		self.ticker_stop = false
-		if self.ticker_running.IsZero() {
-			// ticker is not running, start ticker
-			self.ticker_running = time.Now()
-			go self.tick()
+	heap.Push(&self.expiry, c)
+}
+func (self *Lagoon) removeExpiry(
+	c *pooledConn,
+) {
+	// assumed that we're locked
+	if c.index < 0 {
+		return
+	}
+	heap.Remove(&self.expiry, c.index)
+}
+func (self *Lagoon) toggleTick() {
+	// assumed that we're locked
+	// called whenever available changes
+	self.wantRefill()
+	if idling := len(self.available) > 0; idling != self.idling {
+		// let our buffer know that we're holding idle slots
+		self.idling = idling
+		self.config.Buffer.idling(self, idling)
+	}
+	if len(self.expiry) == 0 || self.closed {
+		// nothing can expire, once closed we never tick again
+		if !self.timer_deadline.IsZero() {
+			self.timer.Stop()
+			self.timer_deadline = time.Time{}
 		}
+		return
 	}
+	// wake up exactly when our next connection expires
+	next := self.expiry[0].deadline
+	if next.Equal(self.timer_deadline) {
+		// already scheduled
+		return
+	}
+	self.timer_deadline = next
+	if self.timer == nil {
+		self.timer = time.AfterFunc(time.Until(next), self.tick)
+		return
+	}
+	self.timer.Stop()
+	self.timer.Reset(time.Until(next))
 }
 func (self *Lagoon) tick() {
-	defer func() {
-		// close our ticker
-		self.mu.Lock()
-		self.ticker_stop = false
-		self.ticker_running = time.Time{}
-		self.mu.Unlock()
-	}()
-	for {
-		// tick
-		self.mu.Lock()
-		if self.ticker_stop {
-			self.mu.Unlock()
-			// ticker is stopped
-			return
-		}
-		// check idle
-		now := time.Now()
-		for c, _ := range self.available {
-			c.mu.Lock()
-			if now.After(c.idle.Add(self.config.IdleTimeout)) {
-				// timedout - mark as disabled
-				c.disabled = true
-				// remove from lagoon
-				c.remove()
-			}
-			c.mu.Unlock()
+	self.mu.Lock()
+	// our timer has fired
+	self.timer_deadline = time.Time{}
+	// only connections that have reached their deadline are visited
+	now := time.Now()
+	var closing []*pooledConn
+	for len(self.expiry) > 0 && !self.expiry[0].deadline.After(now) {
+		c := self.expiry[0]
+		if self.config.IdleTimeout > 0 && !c.idle.Add(self.config.IdleTimeout).After(now) {
+			atomic.AddInt64(&self.stats.idle_evictions, 1)
+			self.later(self.config.OnIdleEvict, c)
+		} else {
+			atomic.AddInt64(&self.stats.expirations, 1)
 		}
-		self.mu.Unlock()
-		// sleep
-		<-time.After(self.config.TickEvery)
+		c.mu.Lock()
+		// timedout or expired - mark as disabled
+		c.disabled = true
+		// remove from lagoon
+		c.remove()
+		c.mu.Unlock()
+		closing = append(closing, c)
 	}
+	// schedule our next deadline
+	self.toggleTick()
+	self.unlock()
+	// close outside of our lock
+	discardConnections(closing)
 }
@@ -0,0 +1,315 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sabey.co/lagoon"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CONTENT_TYPE_OPENMETRICS = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	CONTENT_TYPE_TEXT        = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var (
+	ERR_NAME_EMPTY  = fmt.Errorf("Name Empty")
+	ERR_LAGOON_NIL  = fmt.Errorf("Lagoon NIL")
+	ERR_BUFFER_NIL  = fmt.Errorf("Buffer NIL")
+	ERR_NAME_EXISTS = fmt.Errorf("Name Already Registered")
+)
+
+// the default registry used by the package level functions
+var Default = CreateRegistry()
+
+type Registry struct {
+	// unsafe
+	lagoons map[string]*lagoon.Lagoon
+	buffers map[string]*lagoon.Buffer
+	mu      sync.RWMutex
+}
+
+func CreateRegistry() *Registry {
+	return &Registry{
+		lagoons: make(map[string]*lagoon.Lagoon),
+		buffers: make(map[string]*lagoon.Buffer),
+	}
+}
+func RegisterLagoon(
+	name string,
+	l *lagoon.Lagoon,
+) error {
+	return Default.RegisterLagoon(name, l)
+}
+func UnregisterLagoon(
+	name string,
+) {
+	Default.UnregisterLagoon(name)
+}
+func RegisterBuffer(
+	name string,
+	b *lagoon.Buffer,
+) error {
+	return Default.RegisterBuffer(name, b)
+}
+func UnregisterBuffer(
+	name string,
+) {
+	Default.UnregisterBuffer(name)
+}
+func Handler() http.Handler {
+	return Default
+}
+func (self *Registry) RegisterLagoon(
+	name string,
+	l *lagoon.Lagoon,
+) error {
+	if name == "" {
+		return ERR_NAME_EMPTY
+	}
+	if !l.IsValid() {
+		return ERR_LAGOON_NIL
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if _, ok := self.lagoons[name]; ok {
+		return ERR_NAME_EXISTS
+	}
+	self.lagoons[name] = l
+	return nil
+}
+func (self *Registry) UnregisterLagoon(
+	name string,
+) {
+	self.mu.Lock()
+	delete(self.lagoons, name)
+	self.mu.Unlock()
+}
+func (self *Registry) RegisterBuffer(
+	name string,
+	b *lagoon.Buffer,
+) error {
+	if name == "" {
+		return ERR_NAME_EMPTY
+	}
+	if b == nil {
+		return ERR_BUFFER_NIL
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if _, ok := self.buffers[name]; ok {
+		return ERR_NAME_EXISTS
+	}
+	self.buffers[name] = b
+	return nil
+}
+func (self *Registry) UnregisterBuffer(
+	name string,
+) {
+	self.mu.Lock()
+	delete(self.buffers, name)
+	self.mu.Unlock()
+}
+func (self *Registry) ServeHTTP(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	// prometheus asks for openmetrics when it's able to parse it
+	openmetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openmetrics {
+		w.Header().Set("Content-Type", CONTENT_TYPE_OPENMETRICS)
+	} else {
+		w.Header().Set("Content-Type", CONTENT_TYPE_TEXT)
+	}
+	self.write(w, openmetrics)
+}
+func (self *Registry) Write(
+	w io.Writer,
+) error {
+	// always written as openmetrics
+	return self.write(w, true)
+}
+
+type lagoonSample struct {
+	name  string
+	stats lagoon.Stats
+}
+type bufferSample struct {
+	name    string
+	max     int
+	used    int
+	waiting int
+}
+
+func (self *Registry) write(
+	w io.Writer,
+	openmetrics bool,
+) error {
+	// snapshot everything first, we don't want to hold our lock while writing
+	self.mu.RLock()
+	lagoons := make([]lagoonSample, 0, len(self.lagoons))
+	for name, l := range self.lagoons {
+		lagoons = append(lagoons, lagoonSample{name: name, stats: l.Stats()})
+	}
+	buffers := make([]bufferSample, 0, len(self.buffers))
+	for name, b := range self.buffers {
+		buffers = append(buffers, bufferSample{
+			name:    name,
+			max:     b.GetMax(),
+			used:    b.GetUsed(),
+			waiting: b.GetWaiting(),
+		})
+	}
+	self.mu.RUnlock()
+	sort.Slice(lagoons, func(i, j int) bool {
+		return lagoons[i].name < lagoons[j].name
+	})
+	sort.Slice(buffers, func(i, j int) bool {
+		return buffers[i].name < buffers[j].name
+	})
+	e := &encoder{
+		w:           bufio.NewWriter(w),
+		openmetrics: openmetrics,
+	}
+	// pools
+	if len(lagoons) > 0 {
+		gauge := func(name, help string, value func(lagoon.Stats) int) {
+			e.family(name, "gauge", help)
+			for _, s := range lagoons {
+				e.sample(name, "pool", s.name, "", "", strconv.Itoa(value(s.stats)))
+			}
+		}
+		gauge("lagoon_connections", "Connections held by the pool.", func(s lagoon.Stats) int { return s.Connections })
+		gauge("lagoon_connections_available", "Idle connections.", func(s lagoon.Stats) int { return s.Available })
+		gauge("lagoon_connections_active", "Checked out connections.", func(s lagoon.Stats) int { return s.Active })
+		gauge("lagoon_connections_waiting", "Callers waiting for a connection.", func(s lagoon.Stats) int { return s.Waiting })
+		gauge("lagoon_breaker_state", "Circuit breaker state, 0 closed, 1 open, 2 half-open.", func(s lagoon.Stats) int { return int(s.Breaker) })
+		counter := func(name, help string, value func(lagoon.Stats) int64) {
+			e.family(name, "counter", help)
+			for _, s := range lagoons {
+				e.sample(name+"_total", "pool", s.name, "", "", strconv.FormatInt(value(s.stats), 10))
+			}
+		}
+		counter("lagoon_dials", "Dials attempted.", func(s lagoon.Stats) int64 { return s.Dials })
+		counter("lagoon_dials_succeeded", "Dials succeeded.", func(s lagoon.Stats) int64 { return s.DialsSucceeded })
+		counter("lagoon_dials_failed", "Dials failed.", func(s lagoon.Stats) int64 { return s.DialsFailed })
+		counter("lagoon_dial_retries", "Failed dials retried after backing off.", func(s lagoon.Stats) int64 { return s.DialRetries })
+		counter("lagoon_timeouts", "Timeouts waiting on the buffer.", func(s lagoon.Stats) int64 { return s.Timeouts })
+		counter("lagoon_idle_evictions", "Connections closed for being idle.", func(s lagoon.Stats) int64 { return s.IdleEvictions })
+		counter("lagoon_steals", "Idle connections evicted to free a buffer slot for another pool.", func(s lagoon.Stats) int64 { return s.Steals })
+		counter("lagoon_expirations", "Connections closed for exceeding their lifetime or uses.", func(s lagoon.Stats) int64 { return s.Expirations })
+		counter("lagoon_disables", "Connections disabled.", func(s lagoon.Stats) int64 { return s.Disables })
+		counter("lagoon_closes", "Connections closed.", func(s lagoon.Stats) int64 { return s.Closes })
+		counter("lagoon_breaker_opens", "Times the circuit breaker opened.", func(s lagoon.Stats) int64 { return s.BreakerOpens })
+		counter("lagoon_breaker_rejections", "Dials rejected while the circuit breaker was open.", func(s lagoon.Stats) int64 { return s.BreakerRejections })
+		counter("lagoon_leaks", "Connections held for longer than the leak threshold.", func(s lagoon.Stats) int64 { return s.Leaks })
+		counter("lagoon_reclaims", "Connections taken back after exceeding the max checkout duration.", func(s lagoon.Stats) int64 { return s.Reclaims })
+		counter("lagoon_invariants", "Internal states that should never happen.", func(s lagoon.Stats) int64 { return s.Invariants })
+		histogram := func(name, help string, value func(lagoon.Stats) lagoon.Histogram) {
+			e.family(name, "histogram", help)
+			for _, s := range lagoons {
+				e.histogram(name, "pool", s.name, value(s.stats))
+			}
+		}
+		histogram("lagoon_checkout_wait_seconds", "Time spent waiting for a connection.", func(s lagoon.Stats) lagoon.Histogram { return s.WaitHistogram })
+		histogram("lagoon_dial_seconds", "Time spent dialing.", func(s lagoon.Stats) lagoon.Histogram { return s.DialHistogram })
+	}
+	// buffers
+	if len(buffers) > 0 {
+		gauge := func(name, help string, value func(bufferSample) int) {
+			e.family(name, "gauge", help)
+			for _, b := range buffers {
+				e.sample(name, "buffer", b.name, "", "", strconv.Itoa(value(b)))
+			}
+		}
+		gauge("lagoon_buffer_max", "Buffer capacity.", func(b bufferSample) int { return b.max })
+		gauge("lagoon_buffer_used", "Buffer slots in use.", func(b bufferSample) int { return b.used })
+		gauge("lagoon_buffer_waiting", "Callers waiting for a buffer slot.", func(b bufferSample) int { return b.waiting })
+	}
+	if openmetrics {
+		e.line("# EOF")
+	}
+	return e.flush()
+}
+
+type encoder struct {
+	w           *bufio.Writer
+	openmetrics bool
+	err         error
+}
+
+func (self *encoder) line(
+	s string,
+) {
+	if self.err != nil {
+		return
+	}
+	_, self.err = self.w.WriteString(s + "\n")
+}
+func (self *encoder) family(
+	name string,
+	kind string,
+	help string,
+) {
+	if kind == "counter" && !self.openmetrics {
+		// the prometheus text format names counters by their sample
+		name += "_total"
+	}
+	self.line("# HELP " + name + " " + help)
+	self.line("# TYPE " + name + " " + kind)
+}
+func (self *encoder) sample(
+	name string,
+	label string,
+	value string,
+	extra_label string,
+	extra_value string,
+	sample string,
+) {
+	labels := label + `="` + escape(value) + `"`
+	if extra_label != "" {
+		labels += "," + extra_label + `="` + escape(extra_value) + `"`
+	}
+	self.line(name + "{" + labels + "} " + sample)
+}
+func (self *encoder) histogram(
+	name string,
+	label string,
+	value string,
+	h lagoon.Histogram,
+) {
+	// buckets are cumulative
+	var cumulative int64
+	for i, bound := range h.Buckets {
+		cumulative += h.Counts[i]
+		self.sample(name+"_bucket", label, value, "le", seconds(bound), strconv.FormatInt(cumulative, 10))
+	}
+	self.sample(name+"_bucket", label, value, "le", "+Inf", strconv.FormatInt(h.Count, 10))
+	self.sample(name+"_sum", label, value, "", "", seconds(h.Sum))
+	self.sample(name+"_count", label, value, "", "", strconv.FormatInt(h.Count, 10))
+}
+func (self *encoder) flush() error {
+	if self.err != nil {
+		return self.err
+	}
+	return self.w.Flush()
+}
+func seconds(
+	d time.Duration,
+) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+func escape(
+	s string,
+) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return s
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http/httptest"
+	"sabey.co/lagoon"
+	"sabey.co/unittest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	log.Println("TestMetrics")
+
+	buffer := lagoon.CreateBuffer(10, time.Second*2)
+	unittest.NotNil(t, buffer)
+
+	l, err := lagoon.CreateLagoon(&lagoon.Config{
+		Dial: func() (net.Conn, error) {
+			client, server := net.Pipe()
+			server.Close()
+			return client, nil
+		},
+		DialInitial: 2,
+		Buffer:      buffer,
+	})
+	unittest.IsNil(t, err)
+	c, err := l.Dial()
+	unittest.IsNil(t, err)
+	defer c.Close()
+
+	r := CreateRegistry()
+	unittest.IsNil(t, r.RegisterLagoon(`pool "a"`, l))
+	unittest.Equals(t, r.RegisterLagoon(`pool "a"`, l), ERR_NAME_EXISTS)
+	unittest.IsNil(t, r.RegisterBuffer("shared", buffer))
+
+	fmt.Println("openmetrics")
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	unittest.Equals(t, w.Header().Get("Content-Type"), CONTENT_TYPE_OPENMETRICS)
+	body, _ := ioutil.ReadAll(w.Body)
+	text := string(body)
+	unittest.Equals(t, strings.Contains(text, "# TYPE lagoon_dials counter\n"), true)
+	unittest.Equals(t, strings.Contains(text, `lagoon_dials_total{pool="pool \"a\""} 2`+"\n"), true)
+	unittest.Equals(t, strings.Contains(text, `lagoon_connections_available{pool="pool \"a\""} 1`+"\n"), true)
+	unittest.Equals(t, strings.Contains(text, `lagoon_connections_active{pool="pool \"a\""} 1`+"\n"), true)
+	unittest.Equals(t, strings.Contains(text, `lagoon_dial_seconds_bucket{pool="pool \"a\"",le="+Inf"} 2`+"\n"), true)
+	unittest.Equals(t, strings.Contains(text, `lagoon_dial_seconds_count{pool="pool \"a\""} 2`+"\n"), true)
+	unittest.Equals(t, strings.Contains(text, `lagoon_buffer_used{buffer="shared"} 2`+"\n"), true)
+	unittest.Equals(t, strings.HasSuffix(text, "# EOF\n"), true)
+
+	fmt.Println("text")
+	req = httptest.NewRequest("GET", "/metrics", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	unittest.Equals(t, w.Header().Get("Content-Type"), CONTENT_TYPE_TEXT)
+	body, _ = ioutil.ReadAll(w.Body)
+	text = string(body)
+	unittest.Equals(t, strings.Contains(text, "# TYPE lagoon_dials_total counter\n"), true)
+	unittest.Equals(t, strings.Contains(text, "# EOF"), false)
+
+	fmt.Println("unregister")
+	r.UnregisterLagoon(`pool "a"`)
+	r.UnregisterBuffer("shared")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	body, _ = ioutil.ReadAll(w.Body)
+	unittest.Equals(t, string(body), "")
+}
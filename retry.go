@@ -0,0 +1,122 @@
+package lagoon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ERR_RETRY_ATTEMPTS    = fmt.Errorf("Retry Attempts < 1")
+	ERR_RETRY_BACKOFF     = fmt.Errorf("Retry Backoff < 0")
+	ERR_RETRY_BACKOFF_MAX = fmt.Errorf("Retry Backoff Max < 0 Or Less Than Backoff")
+	ERR_RETRY_JITTER      = fmt.Errorf("Retry Jitter < 0")
+)
+
+// failed dials are retried while holding the same buffer slot
+type Retry struct {
+	// total dials including the first, 1 never retries
+	Attempts int
+	// backoff doubles after each failed dial and is capped by BackoffMax, 0 is uncapped
+	Backoff    time.Duration
+	BackoffMax time.Duration
+	// each backoff is lengthened by a random amount up to Jitter
+	Jitter time.Duration
+	// decides whether a dial error is worth retrying, nil retries every error
+	// our context expiring is never retried
+	Retryable func(error) bool
+}
+
+func (self *Retry) Clone() *Retry {
+	if self == nil {
+		return nil
+	}
+	return &Retry{
+		Attempts:   self.Attempts,
+		Backoff:    self.Backoff,
+		BackoffMax: self.BackoffMax,
+		Jitter:     self.Jitter,
+		Retryable:  self.Retryable,
+	}
+}
+func (self *Retry) Validate() error {
+	if self == nil {
+		// retrying is optional
+		return nil
+	}
+	if self.Attempts < 1 {
+		return ERR_RETRY_ATTEMPTS
+	}
+	if self.Backoff < 0 {
+		return ERR_RETRY_BACKOFF
+	}
+	if self.BackoffMax < 0 || (self.BackoffMax > 0 && self.BackoffMax < self.Backoff) {
+		return ERR_RETRY_BACKOFF_MAX
+	}
+	if self.Jitter < 0 {
+		return ERR_RETRY_JITTER
+	}
+	return nil
+}
+func (self *Retry) next(
+	attempt int,
+	err error,
+) (
+	time.Duration,
+	bool,
+) {
+	// how long to wait before dialing again, false if we've given up
+	if self == nil || attempt >= self.Attempts {
+		return 0, false
+	}
+	var e *ContextError
+	if errors.As(err, &e) {
+		// our caller has given up
+		return 0, false
+	}
+	if self.Retryable != nil && !self.Retryable(err) {
+		return 0, false
+	}
+	wait := self.Backoff
+	for i := 1; i < attempt && wait > 0 && wait < math.MaxInt64/2; i++ {
+		if wait *= 2; self.BackoffMax > 0 && wait >= self.BackoffMax {
+			break
+		}
+	}
+	if self.BackoffMax > 0 && wait > self.BackoffMax {
+		wait = self.BackoffMax
+	}
+	if self.Jitter > 0 {
+		wait += time.Duration(rand.Int63n(int64(self.Jitter) + 1))
+	}
+	return wait, true
+}
+func (self *Lagoon) retry(
+	ctx context.Context,
+	attempt int,
+	err error,
+) bool {
+	// assumed that we're NOT locked
+	// assumed that the buffer is still acquired
+	wait, ok := self.config.Retry.next(attempt, err)
+	if !ok || self.IsClosed() {
+		return false
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+		// we'd wake up after our caller has given up
+		return false
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return false
+	}
+	atomic.AddInt64(&self.stats.dial_retries, 1)
+	return true
+}